@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var catCmd = &cobra.Command{
+	Use:   "cat checkpoint:path",
+	Short: "Prints a file as it was in a checkpoint",
+	Long: `Prints the contents of a file as it was saved in a checkpoint, without
+touching the worktree. The path is relative to the root of the repository,
+and the checkpoint can be given in any of the forms that restore accepts,
+like "asd@{0}:README.md" or "15 minutes ago:main.go".`,
+	Args: cobra.ExactArgs(1),
+	Run:  cat,
+}
+
+func cat(cmd *cobra.Command, args []string) {
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	c, name, err := asdRepo.SplitCheckpointPath(args[0])
+	checkError(err)
+
+	f, err := asdRepo.CheckpointFile(c.Hash, name)
+	checkError(err)
+
+	r, err := f.Reader()
+	checkError(err)
+	defer r.Close()
+
+	_, err = io.Copy(os.Stdout, r)
+	checkError(err)
+}
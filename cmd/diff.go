@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff checkpoint[..checkpoint] [--cached | --head] [-- paths...]",
+	Short: "Show changes between a checkpoint and the worktree, the index, HEAD or another checkpoint",
+	Long: `Shows the changes going from a checkpoint to the current worktree.
+With --cached, the checkpoint is compared with the index, and with --head, with
+the checked out commit. Two checkpoints separated by .. are compared with each
+other. Paths (or globs) after -- limit the diff to those files.
+
+Checkpoints can be given as (abbreviated) hashes, as the <n>/<m> numbers
+printed by list, as asd@{n} for the n-th latest autosave, or as a time like
+"15 minutes ago" or "2026-10-17 14:00".`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  diffCheckpoint,
+}
+
+func diffCheckpoint(cmd *cobra.Command, args []string) {
+	cached, err := cmd.Flags().GetBool("cached")
+	checkError(err)
+
+	head, err := cmd.Flags().GetBool("head")
+	checkError(err)
+
+	stat, err := cmd.Flags().GetBool("stat")
+	checkError(err)
+
+	nameOnly, err := cmd.Flags().GetBool("name-only")
+	checkError(err)
+
+	specs, paths := splitArgsAtDash(cmd, args)
+	if len(specs) != 1 {
+		asdFmt.Errorf("Expected exactly one checkpoint or checkpoint range before --\n")
+		os.Exit(1)
+	}
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	var patch *object.Patch
+	if i := strings.Index(specs[0], ".."); i >= 0 {
+		from, to := specs[0][:i], specs[0][i+2:]
+		patch, err = asdRepo.DiffCheckpoints(resolveCheckpoint(asdRepo, from), resolveCheckpoint(asdRepo, to), paths)
+	} else {
+		target := core.DiffWorktree
+		if cached {
+			target = core.DiffIndex
+		} else if head {
+			target = core.DiffHead
+		}
+
+		patch, err = asdRepo.DiffCheckpoint(resolveCheckpoint(asdRepo, specs[0]), target, paths)
+	}
+	checkError(err)
+
+	switch {
+	case nameOnly:
+		for _, fp := range patch.FilePatches() {
+			from, to := fp.Files()
+			if to != nil {
+				asdFmt.Printf("%s\n", to.Path())
+			} else {
+				asdFmt.Printf("%s\n", from.Path())
+			}
+		}
+	case stat:
+		asdFmt.Printf("%s", patch.Stats().String())
+	default:
+		encoder := diff.NewUnifiedEncoder(os.Stdout, diff.DefaultContextLines)
+		if !color.NoColor {
+			encoder.SetColor(diff.NewColorConfig())
+		}
+
+		err = encoder.Encode(patch)
+		checkError(err)
+	}
+}
+
+// splitArgsAtDash separates the positional arguments from the paths given
+// after --
+func splitArgsAtDash(cmd *cobra.Command, args []string) ([]string, []string) {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 {
+		return args, nil
+	}
+
+	return args[:dash], args[dash:]
+}
+
+// resolveCheckpoint turns any of the ways of referring to a checkpoint into
+// its hash, and exits if it can't be found
+func resolveCheckpoint(asdRepo *core.AsdRepository, spec string) plumbing.Hash {
+	c, err := asdRepo.ResolveCheckpoint(spec)
+	checkError(err)
+
+	return c.Hash
+}
+
+// resolveSavedCheckpoint is resolveCheckpoint for commands that only work on
+// checkpoints, and exits if spec is any other commit
+func resolveSavedCheckpoint(asdRepo *core.AsdRepository, spec string) plumbing.Hash {
+	c, err := asdRepo.ResolveSavedCheckpoint(spec)
+	checkError(err)
+
+	return c.Hash
+}
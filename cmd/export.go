@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export checkpoint {--to dir | --format tar|tar.gz|zip [-o file]}",
+	Short: "Writes the files of a checkpoint to a directory or an archive",
+	Long: `Writes the files of a checkpoint somewhere outside of the worktree, to
+look at or build an old state without disturbing the current one. The
+repository itself is never modified.
+
+With --to, the files are written into a new (or empty) directory. With
+--format, an archive is written to the file given with -o, or to stdout.
+The checkpoint can be given in any of the forms that restore accepts.`,
+	Args: cobra.ExactArgs(1),
+	Run:  export,
+}
+
+func export(cmd *cobra.Command, args []string) {
+	dir, err := cmd.Flags().GetString("to")
+	checkError(err)
+
+	format, err := cmd.Flags().GetString("format")
+	checkError(err)
+
+	output, err := cmd.Flags().GetString("output")
+	checkError(err)
+
+	if (dir == "") == (format == "") {
+		asdFmt.Errorf("Expected exactly one of --to and --format\n")
+		os.Exit(1)
+	}
+
+	if dir != "" && output != "" {
+		asdFmt.Errorf("-o can only be used with --format\n")
+		os.Exit(1)
+	}
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	hash := resolveCheckpoint(asdRepo, args[0])
+
+	if dir != "" {
+		err = asdRepo.ExportToDir(hash, dir)
+		checkError(err)
+
+		asdFmt.Successf("Exported checkpoint %s to %s\n", hash.String()[:7], dir)
+		return
+	}
+
+	if output == "" {
+		err = asdRepo.ExportArchive(hash, core.ExportFormat(format), os.Stdout)
+		checkError(err)
+		return
+	}
+
+	f, err := os.Create(output)
+	checkError(err)
+
+	err = asdRepo.ExportArchive(hash, core.ExportFormat(format), f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+	}
+	checkError(err)
+
+	asdFmt.Successf("Exported checkpoint %s to %s\n", hash.String()[:7], output)
+}
@@ -1,22 +1,49 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/nikochiko/autosaved/core"
 	"github.com/spf13/cobra"
+	"github.com/xeonx/timeago"
 )
 
 const defaultAutosaves = 5
 
 var listCmd = &cobra.Command{
-	Use:   "list [n]",
+	Use:   "list [n] [--since time] [--until time] [--branch name | --all] [--stat | --name-status] [-- paths...]",
 	Short: "Lists the last n (default: 10) commits and the related saves",
 	Long: `Gets a list of the commits made by the user starting from HEAD,
 along with the related autosaves / manual saves done using autosaved. This
-format helps in identifying the relevant autosaves and in restoring to one.`,
-	Args: cobra.MaximumNArgs(1),
-	Run:  list,
+format helps in identifying the relevant autosaves and in restoring to one.
+
+--since and --until only show what was committed or saved in that range, and
+paths (or globs) after -- only what changed those files. A commit is shown
+if it matches, or with only the autosaves that match. Times are given like
+"2 hours ago", "yesterday" or "2026-10-17 14:00".
+
+--branch lists another branch instead of the current one, and --all lists
+every chain of autosaves, newest first, even ones whose commit is no longer
+on any branch. Autosaves that <n>/<m> wouldn't find, because their commit
+has autosaves on the current branch too, are named by their hashes.
+
+--stat shows the files changed by each autosave with the lines added and
+removed, and --name-status whether each was added, modified or deleted.
+
+With --json, --jsonl or --format, the commits are printed for scripts
+instead, each with its checkpoints. --format takes a Go template that is
+run for each commit, like '{{.Hash}} {{len .Checkpoints}}'.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		specs, _ := splitArgsAtDash(cmd, args)
+		return cobra.MaximumNArgs(1)(cmd, specs)
+	},
+	Run: list,
 }
 
 func list(cmd *cobra.Command, args []string) {
@@ -26,6 +53,34 @@ func list(cmd *cobra.Command, args []string) {
 		autosaves = defaultAutosaves
 	}
 
+	opts := core.ListOptions{CheckpointLimit: autosaves}
+
+	opts.Branch, err = cmd.Flags().GetString("branch")
+	checkError(err)
+
+	opts.All, err = cmd.Flags().GetBool("all")
+	checkError(err)
+
+	var view listView
+	view.stat, err = cmd.Flags().GetBool("stat")
+	checkError(err)
+
+	view.nameStatus, err = cmd.Flags().GetBool("name-status")
+	checkError(err)
+
+	if view.stat && view.nameStatus {
+		asdFmt.Errorf("Only one of --stat and --name-status can be used\n")
+		os.Exit(1)
+	}
+
+	view.showBranch = opts.All
+	opts.Stats = view.stat || view.nameStatus
+
+	opts.Since = timeFlag(cmd, "since")
+	opts.Until = timeFlag(cmd, "until")
+
+	args, opts.Paths = splitArgsAtDash(cmd, args)
+
 	limit := 10
 	if len(args) > 0 {
 		limitString := args[0]
@@ -41,6 +96,162 @@ func list(cmd *cobra.Command, args []string) {
 	asdRepo, err := core.AsdRepoFromGitRepoPath(path, getMinSeconds())
 	checkError(err)
 
-	err = asdRepo.List(limit, autosaves)
+	opts.Limit = limit
+	entries, err := asdRepo.List(opts)
+	checkError(err)
+
+	render(cmd, entries, view.render)
+}
+
+// timeFlag reads a time flag, which is zero when it isn't given
+func timeFlag(cmd *cobra.Command, name string) time.Time {
+	s, err := cmd.Flags().GetString(name)
+	checkError(err)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := core.ParseTime(s)
 	checkError(err)
+
+	return t
+}
+
+// listView is how list prints the commits and their autosaves for people
+type listView struct {
+	// showBranch shows the branch of each chain, for when they can be
+	// from different branches
+	showBranch bool
+	stat       bool
+	nameStatus bool
+}
+
+func (view listView) render(w io.Writer, v interface{}) error {
+	entries := v.([]core.ListEntry)
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "Nothing to list")
+		return nil
+	}
+
+	for _, e := range entries {
+		view.printEntry(w, e)
+	}
+
+	return nil
+}
+
+func (view listView) printEntry(w io.Writer, e core.ListEntry) {
+	branch := ""
+	if view.showBranch {
+		branch = e.Branch
+	}
+	fmt.Fprintln(w, formatCommit(e.Index, e.Commit, branch))
+
+	if len(e.Checkpoints) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\tAutosaves:")
+	for _, c := range e.Checkpoints {
+		fmt.Fprint(w, shortFormatCommit("\t", specLabel(c), c.Commit))
+
+		if c.Stats != nil {
+			switch {
+			case view.stat:
+				printStat(w, "\t\t", c.Stats)
+			case view.nameStatus:
+				printNameStatus(w, "\t\t", c.Stats)
+			}
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	if e.MoreCheckpoints {
+		fmt.Fprint(w, "\t...\n\n")
+	}
+}
+
+// printStat prints a line per file with the number of lines changed, and a
+// summary, like git's --stat
+func printStat(w io.Writer, prefix string, s *core.Stats) {
+	width := 0
+	for _, f := range s.Files {
+		if len(f.Path) > width {
+			width = len(f.Path)
+		}
+	}
+
+	for _, f := range s.Files {
+		fmt.Fprintf(w, "%s%-*s | %4d %s%s\n", prefix, width, f.Path, f.Added+f.Removed,
+			successDisplay.Sprint(strings.Repeat("+", statBarLength(f.Added))),
+			errDisplay.Sprint(strings.Repeat("-", statBarLength(f.Removed))))
+	}
+
+	fmt.Fprintf(w, "%s%s\n", prefix, statSummary(s))
+}
+
+// maxStatBar is the most + or - shown for a file by printStat
+const maxStatBar = 20
+
+func statBarLength(n int) int {
+	if n > maxStatBar {
+		return maxStatBar
+	}
+
+	return n
+}
+
+func statSummary(s *core.Stats) string {
+	files := "files"
+	if len(s.Files) == 1 {
+		files = "file"
+	}
+
+	return fmt.Sprintf("%d %s changed, %d insertions(+), %d deletions(-)", len(s.Files), files, s.Added, s.Removed)
+}
+
+// printNameStatus prints the status and name of each file changed, like
+// git's --name-status
+func printNameStatus(w io.Writer, prefix string, s *core.Stats) {
+	for _, f := range s.Files {
+		fmt.Fprintf(w, "%s%s\t%s\n", prefix, f.Status, f.Path)
+	}
+}
+
+func formatCommit(serialNumber int, commit core.Commit, branch string) string {
+	commitLine := color.New(color.FgYellow).Sprintf("%d\tcommit %s", serialNumber, commit.Hash)
+	authorLine := fmt.Sprintf("Author:\t%s <%s>", commit.AuthorName, commit.AuthorEmail)
+	if branch != "" {
+		authorLine += fmt.Sprintf("\nBranch:\t%s", branch)
+	}
+	dateLine := fmt.Sprintf("When:\t%s", timeago.English.Format(commit.When))
+	msgLine := fmt.Sprintf("\t%s", commit.Message)
+	if msgLine[len(msgLine)-1] != '\n' {
+		msgLine += "\n"
+	}
+
+	return fmt.Sprintf("%s\n%s\n%s\n\n%s", commitLine, authorLine, dateLine, msgLine)
+}
+
+// specLabel is the spec to print next to a checkpoint's hash, shortened when
+// it is the hash itself
+func specLabel(c core.Checkpoint) string {
+	if c.Spec == c.Hash {
+		return c.Hash[:7]
+	}
+
+	return c.Spec
+}
+
+func shortFormatCommit(prefix string, serialNumber string, commit core.Commit) string {
+	commitLine := prefix + color.New(color.FgYellow).Sprintf("%s\t%s", serialNumber, commit.Hash)
+	whenLine := prefix + fmt.Sprintf("When:\t%s", timeago.English.Format(commit.When))
+	msgLine := prefix + fmt.Sprintf("\t%s", commit.Message)
+	if msgLine[len(msgLine)-1] != '\n' {
+		msgLine += "\n"
+	}
+
+	return fmt.Sprintf("%s\n%s\n%s", commitLine, whenLine, msgLine)
 }
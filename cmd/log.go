@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log [-n count] [-p] -- path",
+	Short: "Shows how a file changed across autosaves",
+	Long: `Lists the versions of a file saved in the autosaves of the commits of the
+current branch, newest first, with when each was saved and the checkpoint
+that has it. Autosaves that didn't change the file are skipped. With -p, the
+patch from the version before is shown too, or from the commit for the
+oldest one.
+
+The path is relative to the root of the repository.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		specs, paths := splitArgsAtDash(cmd, args)
+		if len(specs) > 0 || len(paths) != 1 {
+			return fmt.Errorf("expected exactly one path after --")
+		}
+
+		return nil
+	},
+	Run: fileLog,
+}
+
+// loggedVersion is what log prints for each version, the patch is only set
+// with -p
+type loggedVersion struct {
+	core.FileVersion
+	Patch string `json:"patch,omitempty"`
+}
+
+func fileLog(cmd *cobra.Command, args []string) {
+	count, err := cmd.Flags().GetInt("max-count")
+	checkError(err)
+
+	withPatch, err := cmd.Flags().GetBool("patch")
+	checkError(err)
+
+	_, paths := splitArgsAtDash(cmd, args)
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	versions, err := asdRepo.FileHistory(paths[0])
+	checkError(err)
+
+	if count > 0 && len(versions) > count {
+		versions = versions[:count]
+	}
+
+	logged := make([]loggedVersion, 0, len(versions))
+	for _, v := range versions {
+		lv := loggedVersion{FileVersion: v}
+
+		if withPatch {
+			patch, err := asdRepo.DiffCheckpoints(plumbing.NewHash(v.Previous), plumbing.NewHash(v.Hash), paths)
+			checkError(err)
+
+			var b bytes.Buffer
+			encoder := diff.NewUnifiedEncoder(&b, diff.DefaultContextLines)
+			if !color.NoColor && !machineReadable(cmd) {
+				encoder.SetColor(diff.NewColorConfig())
+			}
+			checkError(encoder.Encode(patch))
+
+			lv.Patch = b.String()
+		}
+
+		logged = append(logged, lv)
+	}
+
+	render(cmd, logged, printFileLog)
+}
+
+func printFileLog(w io.Writer, v interface{}) error {
+	logged := v.([]loggedVersion)
+	if len(logged) == 0 {
+		fmt.Fprintln(w, "No autosave has this file")
+		return nil
+	}
+
+	for _, lv := range logged {
+		fmt.Fprint(w, shortFormatCommit("", specLabel(lv.Checkpoint), lv.Commit))
+
+		if lv.Deleted {
+			fmt.Fprintln(w, asdFmt.Swarnf("\tdeleted"))
+		}
+
+		if lv.Patch != "" {
+			fmt.Fprintf(w, "\n%s", lv.Patch)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
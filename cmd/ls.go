@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls checkpoint [path] [-r]",
+	Short: "Lists the files of a checkpoint",
+	Long: `Lists the files and directories of a checkpoint, in its root or in the
+directory at path, like git ls-tree. With -r, the files inside of every
+subdirectory are listed instead of the subdirectories.
+
+The checkpoint can be given in any of the forms that restore accepts.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  ls,
+}
+
+func ls(cmd *cobra.Command, args []string) {
+	recursive, err := cmd.Flags().GetBool("recursive")
+	checkError(err)
+
+	dir := ""
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	hash := resolveCheckpoint(asdRepo, args[0])
+
+	entries, err := asdRepo.CheckpointTree(hash, dir, recursive)
+	checkError(err)
+
+	render(cmd, entries, printTree)
+}
+
+func printTree(w io.Writer, v interface{}) error {
+	for _, e := range v.([]core.TreeEntry) {
+		fmt.Fprintf(w, "%s %s %s %8s\t%s\n", e.Mode, e.Type, e.Hash, sizeString(e), e.Path)
+	}
+
+	return nil
+}
+
+func sizeString(e core.TreeEntry) string {
+	if e.Type != "blob" {
+		return "-"
+	}
+
+	return fmt.Sprint(e.Size)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [path-to-repo]",
+	Short: "Move checkpoints saved by older versions out of the branch list",
+	Long: `Older versions of autosaved stored checkpoints in branches named
+_asd_<commit-hash>. This moves all of them into the refs/autosaved/
+namespace, where newer versions look for them, and deletes the old branches.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  migrate,
+}
+
+func migrate(cmd *cobra.Command, args []string) {
+	repoPath := "."
+	if len(args) > 0 {
+		repoPath = args[0]
+	}
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(repoPath, getMinSeconds())
+	checkError(err)
+
+	migrations, err := asdRepo.MigrateLegacyBranches()
+	for _, m := range migrations {
+		if m.Skipped != "" {
+			asdFmt.Warnf("Skipped %s: %s\n", m.From.Short(), m.Skipped)
+			continue
+		}
+
+		asdFmt.Printf("Moved %s to %s\n", m.From.Short(), m.To)
+	}
+	checkError(err)
+
+	if len(migrations) == 0 {
+		asdFmt.Printf("No branches to migrate\n")
+		return
+	}
+
+	asdFmt.Successf("Migrated successfully\n")
+}
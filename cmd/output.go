@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/nikochiko/autosaved/output"
+	"github.com/spf13/cobra"
+)
+
+// addOutputFlags adds the flags choosing how a command prints its result
+func addOutputFlags(c *cobra.Command) {
+	c.Flags().Bool("json", false, "print as indented JSON")
+	c.Flags().Bool("jsonl", false, "print as JSON, one object per line")
+	c.Flags().String("format", "", "print each item with a Go template, like '{{.Hash}} {{.When}}'")
+}
+
+// machineReadable reports whether one of the flags of addOutputFlags was
+// given, so that only the result should be printed
+func machineReadable(cmd *cobra.Command) bool {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	asJSONLines, _ := cmd.Flags().GetBool("jsonl")
+	format, _ := cmd.Flags().GetString("format")
+
+	return asJSON || asJSONLines || format != ""
+}
+
+// render prints v with the renderer picked by the flags of addOutputFlags,
+// or with text when none of them is given
+func render(cmd *cobra.Command, v interface{}, text output.RendererFunc) {
+	asJSON, err := cmd.Flags().GetBool("json")
+	checkError(err)
+
+	asJSONLines, err := cmd.Flags().GetBool("jsonl")
+	checkError(err)
+
+	format, err := cmd.Flags().GetString("format")
+	checkError(err)
+
+	r, err := output.Select(asJSON, asJSONLines, format, text)
+	checkError(err)
+
+	checkError(r.Render(os.Stdout, v))
+}
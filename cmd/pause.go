@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/daemon"
+	"github.com/spf13/cobra"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause autosaving",
+	Long: `Asks the running daemon to stop checking repositories until
+autosaved resume is run. Manual saves still work.`,
+	Args: cobra.NoArgs,
+	Run:  pause,
+}
+
+func pause(cmd *cobra.Command, args []string) {
+	_, running, err := callDaemon(daemon.Request{Command: daemon.CommandPause})
+	if !running {
+		checkError(daemon.ErrDaemonNotRunning)
+	}
+	checkError(err)
+
+	asdFmt.Successf("Paused autosaving\n")
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/daemon"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [--dry-run] [path-to-repo]",
+	Short: "Delete old checkpoints according to the retention policy",
+	Long: `Applies the retention policy from the config file to every
+autosaved chain of a repository. Dropped checkpoints are removed from their
+chains, and chains left without any checkpoint are deleted.
+Removing a checkpoint rewrites every checkpoint saved after it in its
+chain, so they get new hashes and their <n>/<m> specs shift. Checkpoints
+that a restore can still be undone to are kept as they are. The daemon
+applies the same policy after every check.
+With --dry-run, only prints what would be dropped. With --json, --jsonl
+or --format, each chain is printed with its kept and dropped checkpoints.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  prune,
+}
+
+func prune(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	checkError(err)
+
+	repoPath := "."
+	if len(args) > 0 {
+		repoPath = args[0]
+	}
+
+	policy, err := daemon.LoadRetentionPolicy(globalViper)
+	checkError(err)
+
+	if policy.IsZero() {
+		if machineReadable(cmd) {
+			render(cmd, []prunedChain{}, nil)
+			return
+		}
+
+		asdFmt.Warnf("No retention policy is configured, every checkpoint will be kept\n")
+		return
+	}
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(repoPath, getMinSeconds())
+	checkError(err)
+
+	err = asdRepo.SetRetentionPolicy(policy)
+	checkError(err)
+
+	results, err := asdRepo.Prune(core.PruneOptions{DryRun: dryRun})
+	if machineReadable(cmd) {
+		checkError(err)
+
+		chains := make([]prunedChain, 0, len(results))
+		for _, result := range results {
+			chains = append(chains, newPrunedChain(result, dryRun))
+		}
+
+		render(cmd, chains, nil)
+		return
+	}
+
+	for _, result := range results {
+		verb := "Dropped"
+		if dryRun {
+			verb = "Would drop"
+		}
+
+		asdFmt.Printf("%s %d of %d checkpoints from %s\n", verb, len(result.Dropped), len(result.Dropped)+len(result.Kept), result.Ref)
+		if dryRun {
+			for _, c := range result.Dropped {
+				asdFmt.Printf("\t%s %s\n", c.Hash.String(), c.Committer.When.Format("2006-01-02 15:04:05"))
+			}
+		}
+
+		if result.Deleted {
+			asdFmt.Printf("\t(no checkpoints are left, so the ref is removed)\n")
+		}
+	}
+	checkError(err)
+
+	if len(results) == 0 {
+		asdFmt.Printf("Nothing to prune\n")
+		return
+	}
+
+	if !dryRun {
+		asdFmt.Successf("Pruned successfully\n")
+	}
+}
+
+// prunedChain is what --json, --jsonl and --format print for each chain
+type prunedChain struct {
+	Ref     string        `json:"ref"`
+	DryRun  bool          `json:"dry_run"`
+	Kept    []core.Commit `json:"kept"`
+	Dropped []core.Commit `json:"dropped"`
+	Deleted bool          `json:"deleted"`
+}
+
+func newPrunedChain(result core.PruneResult, dryRun bool) prunedChain {
+	chain := prunedChain{
+		Ref:     result.Ref.String(),
+		DryRun:  dryRun,
+		Kept:    []core.Commit{},
+		Dropped: []core.Commit{},
+		Deleted: result.Deleted,
+	}
+
+	for _, c := range result.Kept {
+		chain.Kept = append(chain.Kept, core.NewCommit(c))
+	}
+	for _, c := range result.Dropped {
+		chain.Dropped = append(chain.Dropped, core.NewCommit(c))
+	}
+
+	return chain
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/daemon"
+	"github.com/spf13/cobra"
+)
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the daemon read its config file again",
+	Long: `Asks the running daemon to read the config file again. The daemon
+notices changes to the file by itself, this is for when it can't. An invalid
+config is rejected and the daemon keeps the one it has.`,
+	Args: cobra.NoArgs,
+	Run:  reload,
+}
+
+func reload(cmd *cobra.Command, args []string) {
+	_, running, err := callDaemon(daemon.Request{Command: daemon.CommandReload})
+	if !running {
+		checkError(daemon.ErrDaemonNotRunning)
+	}
+	checkError(err)
+
+	asdFmt.Successf("Reloaded the config\n")
+}
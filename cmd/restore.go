@@ -1,27 +1,96 @@
 package cmd
 
 import (
+	"errors"
+	"os"
+
 	"github.com/nikochiko/autosaved/core"
 	"github.com/spf13/cobra"
 )
 
 var restoreCmd = &cobra.Command{
-	Use:   "restore commit-hash",
-	Short: "Restores the state of a repository to a previous checkpoint (commit). Input is commit hash that we want to restore",
-	Long: `Restores the state of the repository to a previous state,
-whose commit Hash is given as an argument`,
-	Args: cobra.ExactArgs(1),
+	Use:   "restore {checkpoint [-- paths...] | --undo}",
+	Short: "Restores the state of a repository to a previous checkpoint (commit)",
+	Long: `Restores the state of the repository to a previous state.
+The checkpoint can be given as an (abbreviated) commit hash, as the <n>/<m>
+numbers printed by list, as asd@{n} for the n-th latest autosave (asd@{0} is
+the latest), or as a time like "15 minutes ago" or "2026-10-17 14:00", which
+picks the latest checkpoint saved before it. Commits that weren't saved by
+autosaved can't be restored.
+
+When paths (or globs) are given after --, only those files are written back
+from the checkpoint, including files that have been deleted since. All other
+files, HEAD and the index are left untouched.
+
+Before writing anything, the files that will be touched are listed and the
+current worktree is saved as a pre-restore checkpoint. By default (--force)
+the checkpoint's files replace the ones in the worktree. --abort-if-dirty
+refuses to restore when there are uncommitted changes, and --merge merges the
+checkpoint into them, writing conflict markers where both changed the same
+lines.
+
+--undo puts the files touched by the latest restore back the way they were
+before it. It can be repeated to undo earlier restores.`,
+	Args: cobra.ArbitraryArgs,
 	Run:  restore,
 }
 
 func restore(cmd *cobra.Command, args []string) {
+	undoRestore, err := cmd.Flags().GetBool("undo")
+	checkError(err)
+
+	if undoRestore {
+		if len(args) > 0 {
+			asdFmt.Errorf("--undo doesn't take a checkpoint\n")
+			os.Exit(1)
+		}
+
+		undo(cmd, args)
+		return
+	}
+
+	if len(args) == 0 {
+		asdFmt.Errorf("Expected a checkpoint to restore\n")
+		os.Exit(1)
+	}
+
 	repoPath := "."
 	asdRepo, err := core.AsdRepoFromGitRepoPath(repoPath, getMinSeconds())
 	checkError(err)
 
-	hashString := args[0]
+	specs, paths := splitArgsAtDash(cmd, args)
+	if len(specs) != 1 {
+		asdFmt.Errorf("Expected exactly one checkpoint before --\n")
+		os.Exit(1)
+	}
+
+	abortIfDirty, err := cmd.Flags().GetBool("abort-if-dirty")
+	checkError(err)
+
+	merge, err := cmd.Flags().GetBool("merge")
+	checkError(err)
+
+	force, err := cmd.Flags().GetBool("force")
+	checkError(err)
+
+	mode := core.RestoreForce
+	switch {
+	case abortIfDirty && (merge || force), merge && force:
+		asdFmt.Errorf("Only one of --abort-if-dirty, --force and --merge can be used\n")
+		os.Exit(1)
+	case abortIfDirty:
+		mode = core.RestoreAbortIfDirty
+	case merge:
+		mode = core.RestoreMerge
+	}
+
+	hash := resolveSavedCheckpoint(asdRepo, specs[0])
 
-	err = asdRepo.RestoreByCommitHash(hashString)
+	err = asdRepo.RestoreByCommitHash(hash.String(), core.RestoreOptions{Mode: mode, Paths: paths})
+	if errors.Is(err, core.ErrMergeConflicts) {
+		asdFmt.Warnf("%v, resolve the conflict markers in the files marked as conflict above\n", err)
+		os.Exit(1)
+	}
 	checkError(err)
 
 	asdFmt.Successf("Restored successfully\n")
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/daemon"
+	"github.com/spf13/cobra"
+)
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume autosaving after a pause",
+	Long: `Asks the running daemon to check repositories again after
+autosaved pause. Every repository is checked right away.`,
+	Args: cobra.NoArgs,
+	Run:  resume,
+}
+
+func resume(cmd *cobra.Command, args []string) {
+	_, running, err := callDaemon(daemon.Request{Command: daemon.CommandResume})
+	if !running {
+		checkError(daemon.ErrDaemonNotRunning)
+	}
+	checkError(err)
+
+	asdFmt.Successf("Resumed autosaving\n")
+}
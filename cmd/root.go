@@ -1,11 +1,12 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 
+	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/logging"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -20,6 +21,9 @@ var (
 
 var globalViper = viper.GetViper()
 
+// cmdLog is the log of the command, on stderr
+var cmdLog = logging.New(os.Stderr, logging.LevelInfo, logging.FormatLogfmt)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "autosaved",
@@ -65,14 +69,68 @@ func init() {
 
 	rootCmd.AddCommand(stopCmd)
 
+	rootCmd.AddCommand(statusCmd)
+	addOutputFlags(statusCmd)
+
 	rootCmd.AddCommand(watchCmd)
 
 	rootCmd.AddCommand(unwatchCmd)
 
+	rootCmd.AddCommand(pauseCmd)
+
+	rootCmd.AddCommand(resumeCmd)
+
+	rootCmd.AddCommand(reloadCmd)
+
 	rootCmd.AddCommand(listCmd)
 	listCmd.Flags().Int("autosaves", defaultAutosaves, "maximum number of autosaves to display per commit")
+	listCmd.Flags().String("since", "", "only show what was committed or saved after this time")
+	listCmd.Flags().String("until", "", "only show what was committed or saved before this time")
+	listCmd.Flags().String("branch", "", "list this branch instead of the current one")
+	listCmd.Flags().Bool("all", false, "list every chain of autosaves, even of commits not on any branch")
+	listCmd.Flags().Bool("stat", false, "show the files changed by each autosave, with the lines added and removed")
+	listCmd.Flags().Bool("name-status", false, "show the names and status of the files changed by each autosave")
+	addOutputFlags(listCmd)
 
 	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().Bool("force", false, "overwrite uncommitted changes with the checkpoint (default)")
+	restoreCmd.Flags().Bool("abort-if-dirty", false, "don't restore when the worktree has uncommitted changes")
+	restoreCmd.Flags().Bool("merge", false, "merge the checkpoint into uncommitted changes, writing conflict markers")
+	restoreCmd.Flags().Bool("undo", false, "undo the latest restore")
+
+	rootCmd.AddCommand(undoCmd)
+
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().Bool("cached", false, "compare with the index instead of the worktree")
+	diffCmd.Flags().Bool("head", false, "compare with HEAD instead of the worktree")
+	diffCmd.Flags().Bool("stat", false, "show a diffstat instead of the patch")
+	diffCmd.Flags().Bool("name-only", false, "show only the names of changed files")
+
+	rootCmd.AddCommand(migrateCmd)
+
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().Bool("dry-run", false, "only show which checkpoints would be dropped")
+	addOutputFlags(pruneCmd)
+
+	rootCmd.AddCommand(showCmd)
+	showCmd.Flags().Bool("stat", false, "leave out the patch")
+	addOutputFlags(showCmd)
+
+	rootCmd.AddCommand(catCmd)
+
+	rootCmd.AddCommand(logCmd)
+	logCmd.Flags().IntP("max-count", "n", 0, "show at most this many versions")
+	logCmd.Flags().BoolP("patch", "p", false, "show the patch from the version before")
+	addOutputFlags(logCmd)
+
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.Flags().BoolP("recursive", "r", false, "list the files inside of subdirectories too")
+	addOutputFlags(lsCmd)
+
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("to", "", "directory to write the files into")
+	exportCmd.Flags().String("format", "", "archive format: tar, tar.gz or zip")
+	exportCmd.Flags().StringP("output", "o", "", "file to write the archive to, instead of stdout")
 }
 
 // get one of available config path from environment variable, $HOME/.config, $HOME
@@ -143,14 +201,40 @@ func initConfig() {
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	err := viper.ReadInConfig()
+	initLogger()
+
+	if err == nil {
+		cmdLog.Op("config").Debugf("using config file %s", viper.ConfigFileUsed())
 	} else if reflect.TypeOf(err) == reflect.TypeOf(viper.ConfigFileNotFoundError{}) {
-		fmt.Println("Config file not found. Writing config to file now")
+		cmdLog.Op("config").Infof("config file not found, writing config to file now")
 		viper.SafeWriteConfig()
 	}
 }
 
+// initLogger sets up the log of the command, and of core, from log_level and
+// log_format. The daemon sets up its own once it starts.
+func initLogger() {
+	level, levelErr := logging.ParseLevel(globalViper.GetString("log_level"))
+	if !globalViper.IsSet("log_level") {
+		level, levelErr = logging.LevelInfo, nil
+	}
+
+	format, formatErr := logging.ParseFormat(globalViper.GetString("log_format"))
+	if !globalViper.IsSet("log_format") {
+		format, formatErr = logging.FormatLogfmt, nil
+	}
+
+	cmdLog = logging.New(os.Stderr, level, format)
+	core.SetLogger(cmdLog)
+
+	for _, err := range []error{levelErr, formatErr} {
+		if err != nil {
+			cmdLog.Op("config").Warnf("%v", err)
+		}
+	}
+}
+
 func getMinSeconds() int {
 	return 60*afterMinutes + afterSeconds
 }
@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"path/filepath"
+
 	"github.com/go-git/go-git/v5"
 	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/daemon"
 	"github.com/spf13/cobra"
 )
 
@@ -30,6 +34,18 @@ func save(cmd *cobra.Command, args []string) {
 		repoPath = args[0]
 	}
 
+	// a watched repository is saved by the daemon, so that it doesn't race
+	// with a check of the same repository
+	if absPath, err := filepath.Abs(repoPath); err == nil {
+		_, running, err := callDaemon(daemon.Request{Command: daemon.CommandSave, Path: absPath, Message: msg})
+		if running && !errors.Is(err, daemon.ErrRepoNotWatched) {
+			checkError(err)
+
+			asdFmt.Successf("Saved successfully\n")
+			return
+		}
+	}
+
 	gitRepo, err := git.PlainOpen(repoPath)
 	if err != nil {
 		asdFmt.Errorf("Couldn't access Git repository: %v\n", err)
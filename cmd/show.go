@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+	"github.com/xeonx/timeago"
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show checkpoint [--stat] [-- paths...]",
+	Short: "Shows what a checkpoint is and the changes it saved",
+	Long: `Shows when a checkpoint was saved, on which branch and commit, why, and
+the files it changed, followed by the patch from the checkpoint before it (or
+from the commit, for the first one). Paths (or globs) after -- limit the
+patch to those files, and --stat leaves it out.
+
+The checkpoint can be given in any of the forms that restore accepts.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		specs, _ := splitArgsAtDash(cmd, args)
+		return cobra.ExactArgs(1)(cmd, specs)
+	},
+	Run: show,
+}
+
+// shownCheckpoint is what show prints, the patch is left out with --stat
+type shownCheckpoint struct {
+	*core.CheckpointInfo
+	Patch string `json:"patch,omitempty"`
+}
+
+func show(cmd *cobra.Command, args []string) {
+	statOnly, err := cmd.Flags().GetBool("stat")
+	checkError(err)
+
+	specs, paths := splitArgsAtDash(cmd, args)
+
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	hash := resolveSavedCheckpoint(asdRepo, specs[0])
+
+	info, err := asdRepo.DescribeCheckpoint(hash)
+	checkError(err)
+
+	shown := shownCheckpoint{CheckpointInfo: info}
+	if !statOnly {
+		patch, err := asdRepo.CheckpointPatch(hash, paths)
+		checkError(err)
+
+		var b bytes.Buffer
+		encoder := diff.NewUnifiedEncoder(&b, diff.DefaultContextLines)
+		if !color.NoColor && !machineReadable(cmd) {
+			encoder.SetColor(diff.NewColorConfig())
+		}
+		checkError(encoder.Encode(patch))
+
+		shown.Patch = b.String()
+	}
+
+	render(cmd, shown, printShownCheckpoint)
+}
+
+func printShownCheckpoint(w io.Writer, v interface{}) error {
+	s := v.(shownCheckpoint)
+
+	fmt.Fprintln(w, color.New(color.FgYellow).Sprintf("checkpoint %s", s.Hash))
+	fmt.Fprintf(w, "Branch:\t%s\n", s.Branch)
+	fmt.Fprintf(w, "Commit:\t%s %s\n", s.UserCommit.Hash[:7], firstLine(s.UserCommit.Message))
+	fmt.Fprintf(w, "When:\t%s (%s)\n", s.When.Local().Format("2006-01-02 15:04:05"), timeago.English.Format(s.When))
+	fmt.Fprintf(w, "Reason:\t%s\n\n", firstLine(s.Message))
+
+	printStat(w, " ", s.Stats)
+
+	if s.Patch != "" {
+		fmt.Fprintf(w, "\n%s", s.Patch)
+	}
+
+	return nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+
+	return s
+}
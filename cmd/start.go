@@ -17,10 +17,8 @@ Will error if there is already a daemon running`,
 }
 
 func start(cmd *cobra.Command, args []string) {
-	minSeconds := getMinSeconds()
-
 	asdFmt.Successf("Initialising autosave daemon\n")
-	d, err := daemon.New(globalViper, lockfilePath, os.Stdout, os.Stderr, minSeconds)
+	d, err := daemon.New(globalViper, lockfilePath, os.Stdout, os.Stderr)
 	checkError(err)
 
 	asdFmt.Successf("Starting autosave daemon\n")
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nikochiko/autosaved/daemon"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows what the daemon is doing",
+	Long: `Shows whether the daemon is running, the settings it uses, and for
+each watched repository when it was last checked and saved, why it was last
+skipped, its last error and when it will be checked next.`,
+	Args: cobra.NoArgs,
+	Run:  status,
+}
+
+func status(cmd *cobra.Command, args []string) {
+	s, err := daemonStatus()
+	checkError(err)
+
+	render(cmd, s, printStatus)
+}
+
+// daemonStatus asks the running daemon for its status, and falls back to the
+// lockfile and the config file when it can't answer
+func daemonStatus() (*daemon.Status, error) {
+	proc, err := daemon.Owner(lockfilePath)
+	if err != nil && !errors.Is(err, daemon.ErrDaemonNotRunning) {
+		return nil, err
+	}
+
+	resp, running, callErr := callDaemon(daemon.Request{Command: daemon.CommandStatus})
+	if running && callErr == nil {
+		return resp.Status, nil
+	}
+
+	s := daemon.StatusFromConfig(globalViper)
+	if proc != nil {
+		// running, but from before the control socket, or not answering
+		s.Running = true
+		s.PID = proc.Pid
+		if callErr != nil {
+			cmdLog.Op("status").Warnf("the daemon didn't answer: %v", callErr)
+		}
+	}
+
+	return s, nil
+}
+
+func printStatus(w io.Writer, v interface{}) error {
+	s := v.(*daemon.Status)
+
+	switch {
+	case !s.Running:
+		fmt.Fprint(w, asdFmt.Swarnf("autosaved isn't running\n"))
+	case s.StartedAt != nil:
+		fmt.Fprint(w, asdFmt.Ssuccessf("autosaved is running (PID %d, up %s)\n", s.PID, time.Since(*s.StartedAt).Round(time.Second)))
+	default:
+		fmt.Fprint(w, asdFmt.Ssuccessf("autosaved is running (PID %d)\n", s.PID))
+	}
+
+	if s.Paused {
+		fmt.Fprint(w, asdFmt.Swarnf("Autosaving is paused, run `autosaved resume` to start it again\n"))
+	}
+
+	fmt.Fprintf(w, "\nchecking_interval: %s\n", time.Duration(s.CheckingInterval)*time.Second)
+	fmt.Fprintf(w, "after_every:       %s\n", afterEveryString(s.AfterEvery))
+
+	if len(s.Repositories) == 0 {
+		fmt.Fprintf(w, "\nNo repositories are being watched, run `autosaved watch` in one to add it\n")
+		return nil
+	}
+
+	for _, r := range s.Repositories {
+		fmt.Fprintf(w, "\n%s\n", r.Path)
+
+		if !s.Running {
+			continue
+		}
+
+		how := "watching files"
+		if !r.Watching {
+			how = "polling"
+		}
+		if r.Checking {
+			how += ", checking now"
+		}
+		fmt.Fprintf(w, "\t%-12s  %s\n", "mode:", how)
+
+		fmt.Fprintf(w, "\t%-12s  %s\n", "last check:", timeString(r.LastCheck, "not yet"))
+		fmt.Fprintf(w, "\t%-12s  %s\n", "last save:", timeString(r.LastSave, "not yet"))
+		fmt.Fprintf(w, "\t%-12s  %d checks, %d saves, %d skips, %d errors\n", "counts:", r.Checks, r.Saves, r.Skips, r.Errors)
+		if r.LastSkipReason != "" {
+			fmt.Fprintf(w, "\t%-12s  %s\n", "last skip:", r.LastSkipReason)
+		}
+		if r.LastError != "" {
+			fmt.Fprintf(w, "\t%-12s  %s\n", "last error:", asdFmt.Serrorf("%s (%s)", r.LastError, timeString(r.LastErrorAt, "")))
+		}
+		if r.Attention != "" {
+			fmt.Fprintf(w, "\t%-12s  %s\n", "attention:", asdFmt.Swarnf("%s", r.Attention))
+		}
+		if r.Failures > 0 {
+			fmt.Fprintf(w, "\t%-12s  %s\n", "failing:", asdFmt.Swarnf("%d checks in a row", r.Failures))
+		}
+
+		next := timeString(r.NextCheck, "when files change")
+		if s.Paused {
+			next = "when resumed"
+		}
+		fmt.Fprintf(w, "\t%-12s  %s\n", "next check:", next)
+	}
+
+	return nil
+}
+
+func afterEveryString(a daemon.AfterEvery) string {
+	parts := []string{(time.Duration(a.Seconds) * time.Second).String()}
+	if a.Words > 0 {
+		parts = append(parts, fmt.Sprintf("%d words", a.Words))
+	}
+	if a.Lines > 0 {
+		parts = append(parts, fmt.Sprintf("%d lines", a.Lines))
+	}
+	if a.Files > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", a.Files))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// timeString shows t with how long ago or from now it is
+func timeString(t *time.Time, ifNil string) string {
+	if t == nil {
+		return ifNil
+	}
+
+	d := time.Until(*t).Round(time.Second)
+	rel := fmt.Sprintf("in %s", d)
+	if d <= 0 {
+		rel = fmt.Sprintf("%s ago", -d)
+	}
+
+	return fmt.Sprintf("%s (%s)", t.Local().Format("2006-01-02 15:04:05"), rel)
+}
@@ -17,9 +17,7 @@ Will error if there is it isn't running already`,
 }
 
 func stop(cmd *cobra.Command, args []string) {
-	minSeconds := getMinSeconds()
-
-	d, err := daemon.New(globalViper, lockfilePath, os.Stdout, os.Stderr, minSeconds)
+	d, err := daemon.New(globalViper, lockfilePath, os.Stdout, os.Stderr)
 	checkError(err)
 
 	asdFmt.Successf("Stopping autosave daemon\n")
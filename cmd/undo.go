@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/nikochiko/autosaved/core"
+	"github.com/spf13/cobra"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Undoes the latest restore",
+	Long: `Puts the files touched by the latest restore back the way they were
+right before it, using the checkpoint that restore saved. Running it again
+undoes the restore before that one. Same as restore --undo.`,
+	Args: cobra.NoArgs,
+	Run:  undo,
+}
+
+func undo(cmd *cobra.Command, args []string) {
+	asdRepo, err := core.AsdRepoFromGitRepoPath(".", getMinSeconds())
+	checkError(err)
+
+	err = asdRepo.UndoRestore()
+	checkError(err)
+
+	asdFmt.Successf("Undid the restore successfully\n")
+}
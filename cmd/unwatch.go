@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"errors"
 	"path/filepath"
 
+	"github.com/nikochiko/autosaved/daemon"
 	"github.com/spf13/cobra"
 )
 
@@ -16,8 +18,6 @@ from the list of directories being watched by autosaved`,
 }
 
 func unwatch(cmd *cobra.Command, args []string) {
-	repositories := globalViper.GetStringSlice("repositories")
-
 	path := "."
 	if len(args) == 1 {
 		path = args[0]
@@ -29,6 +29,20 @@ func unwatch(cmd *cobra.Command, args []string) {
 		checkError(err)
 	}
 
+	// the daemon writes the config itself, and stops watching right away
+	_, running, err := callDaemon(daemon.Request{Command: daemon.CommandUnwatch, Path: path})
+	if running {
+		if errors.Is(err, daemon.ErrRepoNotWatched) {
+			asdFmt.Errorf("The repo you want to unwatch is not being watched in the first place\n")
+			return
+		}
+		checkError(err)
+
+		asdFmt.Successf("Repo unwatched from autosaved\n")
+		return
+	}
+
+	repositories := globalViper.GetStringSlice("repositories")
 	if !contains(repositories, path) {
 		asdFmt.Errorf("The repo you want to unwatch is not being watched in the first place\n")
 		return
@@ -41,7 +55,7 @@ func unwatch(cmd *cobra.Command, args []string) {
 	}
 
 	globalViper.Set("repositories", repositories)
-	err := globalViper.WriteConfig()
+	err = globalViper.WriteConfig()
 	checkError(err)
 
 	asdFmt.Successf("Repo unwatched from autosaved\n")
@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/fatih/color"
+	"github.com/nikochiko/autosaved/daemon"
 )
 
 type coloredOutput struct {
@@ -41,3 +43,14 @@ func checkError(err error) {
 		os.Exit(1)
 	}
 }
+
+// callDaemon sends a request to the running daemon. running is false when
+// the daemon isn't up, so the caller can do the work itself.
+func callDaemon(req daemon.Request) (resp *daemon.Response, running bool, err error) {
+	resp, err = daemon.Call(lockfilePath, req)
+	if errors.Is(err, daemon.ErrDaemonNotRunning) {
+		return nil, false, nil
+	}
+
+	return resp, true, err
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 
+	"github.com/nikochiko/autosaved/daemon"
 	"github.com/spf13/cobra"
 )
 
@@ -17,8 +19,6 @@ to the list of directories being watched by autosaved`,
 }
 
 func watch(cmd *cobra.Command, args []string) {
-	repositories := globalViper.GetStringSlice("repositories")
-
 	path := "."
 	if len(args) == 1 {
 		path = args[0]
@@ -35,6 +35,20 @@ func watch(cmd *cobra.Command, args []string) {
 		checkError(err)
 	}
 
+	// the daemon writes the config itself, and starts watching right away
+	_, running, err := callDaemon(daemon.Request{Command: daemon.CommandWatch, Path: path})
+	if running {
+		if errors.Is(err, daemon.ErrAlreadyWatched) {
+			asdFmt.Errorf("The repo you want to add is already being watched!\n")
+			return
+		}
+		checkError(err)
+
+		asdFmt.Successf("Repo added to autosaved\n")
+		return
+	}
+
+	repositories := globalViper.GetStringSlice("repositories")
 	if contains(repositories, path) {
 		asdFmt.Errorf("The repo you want to add is already being watched!\n")
 		return
@@ -42,7 +56,7 @@ func watch(cmd *cobra.Command, args []string) {
 
 	repositories = append(repositories, path)
 	globalViper.Set("repositories", repositories)
-	err := globalViper.WriteConfig()
+	err = globalViper.WriteConfig()
 	checkError(err)
 
 	asdFmt.Successf("Repo added to autosaved\n")
@@ -2,24 +2,24 @@ package core
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/fatih/color"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/jinzhu/copier"
-	"github.com/xeonx/timeago"
+	"github.com/nikochiko/autosaved/logging"
 )
 
-// start name with _ because it is the lowest alphabetically, therefore
-// easier for users to scroll down and see the branches they created
+// AutosavedBranchPrefix was used by older versions, which stored checkpoints
+// in branches named _asd_<commit>. These are now moved to AutosavedRefPrefix
+// by MigrateLegacyBranches.
 const AutosavedBranchPrefix = "_asd_"
 
 const (
@@ -39,7 +39,10 @@ var (
 type AsdRepository struct {
 	Repository *git.Repository
 
-	minSeconds int
+	minSeconds           int
+	afterThresholds      ChangeThresholds
+	regardlessThresholds ChangeThresholds
+	retention            RetentionPolicy
 }
 
 // MinimumDuration returns the minimum duration without a commit that will go unsaved
@@ -63,127 +66,123 @@ func AsdRepoFromGitRepoPath(gitPath string, minSeconds int) (*AsdRepository, err
 	return &asdRepo, nil
 }
 
+// Save records the current state of the worktree as a checkpoint on the
+// autosaved ref of the checked out branch and commit. The tree and commit objects
+// are written directly to the object store and the branch is moved with a
+// compare-and-swap, so HEAD, the index and the worktree are never touched.
 func (asd *AsdRepository) Save(msg string) error {
-	w, err := asd.Repository.Worktree()
-	if err != nil {
-		log.Printf("error: %v\n", err)
+	return asd.SaveContext(context.Background(), msg)
+}
 
-		return err
-	}
+// SaveContext is Save, giving up when ctx is done. Nothing is written to the
+// repository until the snapshot is complete.
+func (asd *AsdRepository) SaveContext(ctx context.Context, msg string) error {
+	_, err := asd.save(ctx, msg)
+	return err
+}
 
-	status, err := w.Status()
+// save is SaveContext, returning the hash of the new checkpoint
+func (asd *AsdRepository) save(ctx context.Context, msg string) (plumbing.Hash, error) {
+	r := asd.Repository
+	w, err := r.Worktree()
 	if err != nil {
-		return err
-	}
-
-	if checkNothingToSave(status) {
-		return ErrNothingToSave
+		return plumbing.ZeroHash, err
 	}
 
-	// copy user's index now to recover it later
-	idx, err := asd.Repository.Storer.Index()
+	head, err := r.Head()
 	if err != nil {
-		log.Printf("error: %v\n", err)
-		return err
-	}
-
-	idxCopy := index.Index{}
-	copier.CopyWithOption(&idxCopy, &idx, copier.Option{DeepCopy: true})
-
-	defer func() {
-		// revert to original index
-		err2 := asd.Repository.Storer.SetIndex(&idxCopy)
-		if err2 != nil {
-			log.Printf("error while restoring index: %v\n", err2)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return plumbing.ZeroHash, ErrUserUnbornHead
 		}
-	}()
 
-	head, err := asd.Repository.Head()
+		return plumbing.ZeroHash, err
+	}
+
+	userCommit, err := r.CommitObject(head.Hash())
 	if err != nil {
-		if err == plumbing.ErrReferenceNotFound {
-			err = ErrUserUnbornHead
-			return err
-		}
+		return plumbing.ZeroHash, err
+	}
 
-		log.Printf("error: %v\n", err)
-		return err
+	if onAutosavedBranch(head, userCommit.Committer.Name) {
+		return plumbing.ZeroHash, ErrOnAutosavedBranch
 	}
 
-	err = asd.checkoutAutosavedBranch(w, head)
+	end, err := asd.beginOperation("save", head)
 	if err != nil {
-		log.Printf("error: %v\n", err)
-		return err
+		return plumbing.ZeroHash, fmt.Errorf("couldn't write the operation journal: %w", err)
 	}
+	defer end()
 
-	defer func() {
-		var err2 error
-		if head == nil {
-			err2 = ErrUserUnbornHead
-		} else {
-			err2 = checkoutWithKeep(w, head.Name())
-		}
+	refName := getAutosavedRefName(branchNameFromHead(head), head.Hash())
+	oldRef, err := r.Storer.Reference(refName)
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, err
+	}
 
-		if err2 != nil {
-			log.Printf("error while restoring checked out branch: %v\n", err2)
+	parent := userCommit
+	if oldRef != nil {
+		parent, err = r.CommitObject(oldRef.Hash())
+		if err != nil {
+			return plumbing.ZeroHash, err
 		}
-	}()
+	}
 
-	err = commitAll(w, msg)
+	s := newOverlayStorer(r.Storer)
+	tree, err := asd.snapshotWorktree(ctx, w, s)
 	if err != nil {
-		log.Printf("error: %v\n", err)
-		return err
+		return plumbing.ZeroHash, err
 	}
 
-	return nil
-}
-
-func (asd *AsdRepository) checkoutAutosavedBranch(w *git.Worktree, head *plumbing.Reference) (err error) {
-	branchName := getAutosavedBranchName(head.Hash())
-
-	// try to checkout the branch
-	coOpts := git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(branchName),
-		Keep:   true,
+	if tree == userCommit.TreeHash || tree == parent.TreeHash {
+		return plumbing.ZeroHash, ErrNothingToSave
 	}
-	err = w.Checkout(&coOpts)
-	if err == nil {
-		return
+
+	commit := object.Commit{
+		Author:       *asd.getAuthorSignature(),
+		Committer:    *getAutosavedSignature(),
+		Message:      msg,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{parent.Hash},
 	}
 
-	if !errors.Is(err, plumbing.ErrReferenceNotFound) {
-		return
+	obj := s.NewEncodedObject()
+	if err = commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
 	}
 
-	// if branch doesn't exist, create it while checkout
-	coOpts.Create = true
-	return w.Checkout(&coOpts)
-}
+	commitHash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
 
-func getAutosavedBranchName(commitHash plumbing.Hash) string {
-	return AutosavedBranchPrefix + commitHash.String()
-}
+	if err = s.flush(); err != nil {
+		return plumbing.ZeroHash, err
+	}
 
-func checkoutWithKeep(w *git.Worktree, branchRef plumbing.ReferenceName) error {
-	coOpts := git.CheckoutOptions{
-		Branch: branchRef,
-		Keep:   true,
+	// fails if another process has moved the branch since we read it
+	newRef := plumbing.NewHashReference(refName, commitHash)
+	if err = r.Storer.CheckAndSetReference(newRef, oldRef); err != nil {
+		return plumbing.ZeroHash, err
 	}
 
-	return w.Checkout(&coOpts)
+	asd.log("save").Debugf("saved checkpoint %s on %s", commitHash.String()[:7], refName)
+	return commitHash, nil
 }
 
-func commitAll(w *git.Worktree, msg string) error {
-	commitOptions := git.CommitOptions{
-		All:       true,
-		Committer: getAutosavedSignature(),
-	}
-	_, err := w.Commit(msg, &commitOptions)
-	if err != nil {
-		log.Printf("error: %v\n", err)
-		return err
+// getAuthorSignature returns the user configured in git, the same way
+// `git commit` would pick it up, and falls back to autosaved's signature
+func (asd *AsdRepository) getAuthorSignature() *object.Signature {
+	cfg, err := asd.Repository.ConfigScoped(config.SystemScope)
+	if err != nil || cfg.User.Name == "" || cfg.User.Email == "" {
+		return getAutosavedSignature()
 	}
 
-	return nil
+	sign := object.Signature{
+		Name:  cfg.User.Name,
+		Email: cfg.User.Email,
+		When:  time.Now(),
+	}
+	return &sign
 }
 
 func getAutosavedSignature() *object.Signature {
@@ -226,12 +225,28 @@ func checkNothingToSave(s git.Status) bool {
 	return true
 }
 
+// ShouldSave decides whether the repository should be autosaved now. It
+// returns the reason for the decision either way.
 func (asd *AsdRepository) ShouldSave() (bool, string, error) {
+	return asd.ShouldSaveContext(context.Background())
+}
+
+// ShouldSaveContext is ShouldSave, giving up when ctx is done
+func (asd *AsdRepository) ShouldSaveContext(ctx context.Context) (bool, string, error) {
 	userCommit, err := asd.getLastUserCommitOnCurrentBranch()
 	if err != nil {
 		return false, "", err
 	}
 
+	head, err := asd.Repository.Head()
+	if err != nil {
+		return false, "", err
+	}
+
+	if onAutosavedBranch(head, userCommit.Committer.Name) {
+		return false, "HEAD is on autosaved's own branch or checkpoint", nil
+	}
+
 	autosavedCommit, err := asd.getLastAutosavedCommitForCurrentBranch()
 	if err != nil {
 		if errors.Is(err, ErrAutosavedBranchNotCreated) {
@@ -241,6 +256,32 @@ func (asd *AsdRepository) ShouldSave() (bool, string, error) {
 		}
 	}
 
+	// the stats are only computed when a threshold is configured, and once
+	var stats *ChangeStats
+	getStats := func() (ChangeStats, error) {
+		if stats == nil {
+			s, err := asd.changeStatsSinceLastSave(ctx, userCommit, autosavedCommit)
+			if err != nil {
+				return s, err
+			}
+
+			stats = &s
+		}
+
+		return *stats, nil
+	}
+
+	if !asd.regardlessThresholds.IsZero() {
+		s, err := getStats()
+		if err != nil {
+			return false, "", err
+		}
+
+		if reached := asd.regardlessThresholds.reached(s, "regardless_of_time"); reached != "" {
+			return true, "autosave because " + reached, nil
+		}
+	}
+
 	shouldSave, reason, err := asd.shouldSaveTimeInterval(userCommit, autosavedCommit)
 	if err != nil {
 		return false, "", err
@@ -250,7 +291,7 @@ func (asd *AsdRepository) ShouldSave() (bool, string, error) {
 		return false, reason, nil
 	}
 
-	shouldSave, reason2, err := asd.shouldSaveDiff(userCommit, autosavedCommit)
+	shouldSave, reason2, err := asd.shouldSaveDiff(ctx, userCommit, autosavedCommit)
 	if err != nil {
 		return false, "", err
 	}
@@ -259,6 +300,20 @@ func (asd *AsdRepository) ShouldSave() (bool, string, error) {
 		return false, reason2, nil
 	}
 
+	if !asd.afterThresholds.IsZero() {
+		s, err := getStats()
+		if err != nil {
+			return false, "", err
+		}
+
+		reached := asd.afterThresholds.reached(s, "after_every")
+		if reached == "" {
+			return false, fmt.Sprintf("not enough changes since the last save (%d lines, %d words, %d files)", s.Lines(), s.Words(), s.Files), nil
+		}
+
+		reason2 = reached
+	}
+
 	if reason2 != "" {
 		if reason != "" {
 			reason = reason + " and " + reason2
@@ -290,14 +345,59 @@ func (asd *AsdRepository) shouldSaveTimeInterval(userCommit, autosavedCommit *ob
 	return true, fmt.Sprintf("autosave at %s", timeSinceLastCommit.String()), nil
 }
 
-func (asd *AsdRepository) shouldSaveDiff(userCommit, autosavedCommit *object.Commit) (bool, string, error) {
+// NextSaveTime returns the earliest time at which the minimum duration since
+// the last user commit and the last autosave has passed
+func (asd *AsdRepository) NextSaveTime() (time.Time, error) {
+	userCommit, err := asd.getLastUserCommitOnCurrentBranch()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	last := userCommit.Author.When
+
+	autosavedCommit, err := asd.getLastAutosavedCommitForCurrentBranch()
+	if err != nil && !errors.Is(err, ErrAutosavedBranchNotCreated) {
+		return time.Time{}, err
+	}
+
+	if autosavedCommit != nil && autosavedCommit.Author.When.After(last) {
+		last = autosavedCommit.Author.When
+	}
+
+	return last.Add(time.Duration(asd.minSeconds) * time.Second), nil
+}
+
+// StateFingerprint summarizes HEAD, the commit it points to and the index. It
+// changes when the user commits, checks out, stages or repairs the repository.
+func (asd *AsdRepository) StateFingerprint() string {
+	r := asd.Repository
+
+	var parts []string
+	if head, err := r.Storer.Reference(plumbing.HEAD); err == nil {
+		parts = append(parts, head.String())
+	}
+
+	if head, err := r.Head(); err == nil {
+		parts = append(parts, head.Hash().String())
+	}
+
+	if fs, err := asd.gitDirFilesystem(); err == nil {
+		if fi, err := fs.Stat("index"); err == nil {
+			parts = append(parts, fi.ModTime().String(), strconv.FormatInt(fi.Size(), 10))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (asd *AsdRepository) shouldSaveDiff(ctx context.Context, userCommit, autosavedCommit *object.Commit) (bool, string, error) {
 	r := asd.Repository
 	w, err := r.Worktree()
 	if err != nil {
 		return false, "", err
 	}
 
-	s, err := asd.worktreeStatus(w, userCommit.Hash)
+	s, err := asd.worktreeStatus(ctx, w, userCommit.Hash)
 	if err != nil {
 		return false, "", err
 	}
@@ -307,7 +407,7 @@ func (asd *AsdRepository) shouldSaveDiff(userCommit, autosavedCommit *object.Com
 	}
 
 	if autosavedCommit != nil {
-		s, err = asd.worktreeStatus(w, autosavedCommit.Hash)
+		s, err = asd.worktreeStatus(ctx, w, autosavedCommit.Hash)
 		if err != nil {
 			return false, "", err
 		}
@@ -348,8 +448,7 @@ func (asd *AsdRepository) getLastAutosavedCommitForCurrentBranch() (*object.Comm
 		return nil, err
 	}
 
-	branch := getAutosavedBranchName(head.Hash())
-	refname := plumbing.NewBranchReferenceName(branch)
+	refname := getAutosavedRefName(branchNameFromHead(head), head.Hash())
 
 	ref, err := r.Storer.Reference(refname)
 	if err != nil {
@@ -369,158 +468,31 @@ func (asd *AsdRepository) getLastAutosavedCommitForCurrentBranch() (*object.Comm
 	return commit, nil
 }
 
-func (asd *AsdRepository) List(limit int, asdLimit int) error {
-	r := asd.Repository
-
-	userCommit, err := asd.getLastUserCommitOnCurrentBranch()
-	if err != nil {
-		return err
-	}
-
-	iter := object.NewCommitIterBSF(userCommit, nil, nil)
-	for i := 0; i < limit; i++ {
-		c, err := iter.Next()
-		if err != nil {
-			return err
-		}
-
-		fmt.Println(formatCommit(0, c))
-
-		asdBranchRef, err := getAutosavedBranchRefForCommit(r, c)
-		if err != nil {
-			if errors.Is(err, ErrAutosavedBranchNotFound) {
-				continue
-			}
-
-			return err
-		}
-
-		asdFromCommit, err := r.CommitObject(asdBranchRef.Hash())
-		if err != nil {
-			return err
-		}
-
-		asdIter := object.NewCommitIterBSF(asdFromCommit, nil, nil)
-		for j := 1; j <= asdLimit+1; j++ {
-			asdCommit, err := asdIter.Next()
-			if err != nil {
-				return err
-			}
-
-			if asdCommit.Committer.Name != autosavedSignatureName {
-				break
-			}
-
-			if j == 1 {
-				fmt.Println("\tAutosaves:")
-			}
-
-			if j == asdLimit+1 {
-				// if there are more...
-				fmt.Println("\t...\n")
-				break
-			}
-
-			fmt.Println(shortFormatCommit("\t", j, asdCommit))
-		}
-	}
-
-	return nil
-}
-
-func (asd *AsdRepository) RestoreByCommitHash(hashString string) error {
-	if !plumbing.IsHash(hashString) {
-		return ErrInvalidHash
-	}
-
-	hash := plumbing.NewHash(hashString)
-
-	color.New(color.FgCyan).Printf("\nTip: you can run `git diff HEAD..%s` to confirm your changes\n", hash.String())
-
-	questionString := color.New(color.FgYellow).Sprintf(`Are you sure you want to restore to checkpoint %s?`, hashString[:6])
-
-	if askForConfirmation(questionString) {
-		return asd.restoreCheckpoint(hash)
-	} else {
-		return ErrUserDidNotConfirm
-	}
-}
-
-func (asd *AsdRepository) restoreCheckpoint(commit plumbing.Hash) error {
-	r := asd.Repository
-	w, err := r.Worktree()
-	if err != nil {
-		return err
-	}
-
-	// make note of the current head ref
-	head, err := r.Head()
-	if err != nil {
-		if err == plumbing.ErrReferenceNotFound {
-			err = ErrUserUnbornHead
-			return err
-		}
-
-		log.Printf("error: %v\n", err)
-		return err
-	}
-
-	// force checkout to that commit
-	coOpts := git.CheckoutOptions{
-		Hash:  commit,
-		Force: true,
-	}
-	err = w.Checkout(&coOpts)
-	if err != nil {
-		return err
+// getAutosavedBranchRefForCommit returns the autosaved ref of the user commit
+// c. The ref for the given branch is preferred, but if the commit was
+// autosaved while on another branch, that ref is returned instead.
+func getAutosavedBranchRefForCommit(r *git.Repository, branch string, c *object.Commit) (*plumbing.Reference, error) {
+	ref, err := r.Reference(getAutosavedRefName(branch, c.Hash), true)
+	if err == nil {
+		return ref, nil
 	}
 
-	// git checkout to head with keep
-	err = checkoutWithKeep(w, head.Name())
-	if err != nil {
-		return err
+	if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
 	}
 
-	return nil
-}
-
-func getAutosavedBranchRefForCommit(r *git.Repository, c *object.Commit) (*plumbing.Reference, error) {
-	branchName := getAutosavedBranchName(c.Hash)
-	refName := plumbing.NewBranchReferenceName(branchName)
-
-	ref, err := r.Reference(refName, true)
+	refs, err := autosavedRefs(r)
 	if err != nil {
-		if errors.Is(err, plumbing.ErrReferenceNotFound) {
-			return nil, ErrAutosavedBranchNotFound
-		}
-
 		return nil, err
 	}
 
-	return ref, nil
-}
-
-func formatCommit(serialNumber int, commit *object.Commit) string {
-	commitLine := color.New(color.FgYellow).Sprintf(fmt.Sprintf("%d\tcommit %s", serialNumber, commit.Hash.String()))
-	authorLine := fmt.Sprintf("Author:\t%s <%s>", commit.Author.Name, commit.Author.Email)
-	dateLine := fmt.Sprintf("When:\t%s", timeago.English.Format(commit.Author.When))
-	msgLine := fmt.Sprintf("\t%s", commit.Message)
-	if msgLine[len(msgLine)-1] != '\n' {
-		msgLine += "\n"
-	}
-
-	return fmt.Sprintf("%s\n%s\n%s\n\n%s", commitLine, authorLine, dateLine, msgLine)
-}
-
-func shortFormatCommit(prefix string, serialNumber int, commit *object.Commit) string {
-	commitLine := prefix + color.New(color.FgYellow).Sprintf("%d\t%s", serialNumber, commit.Hash.String())
-	whenLine := prefix + fmt.Sprintf("When:\t%s", timeago.English.Format(commit.Author.When))
-	msgLine := prefix + fmt.Sprintf("\t%s", commit.Message)
-	if msgLine[len(msgLine)-1] != '\n' {
-		msgLine += "\n"
+	for _, ref := range refs {
+		if _, hash, _ := parseAutosavedRefName(ref.Name()); hash == c.Hash {
+			return ref, nil
+		}
 	}
 
-	return fmt.Sprintf("%s\n%s\n%s", commitLine, whenLine, msgLine)
+	return nil, ErrAutosavedBranchNotFound
 }
 
 func askForConfirmation(s string) bool {
@@ -531,7 +503,9 @@ func askForConfirmation(s string) bool {
 
 		response, err := reader.ReadString('\n')
 		if err != nil {
-			log.Fatal(err)
+			// nothing more can be read, so there is no confirmation
+			logger.Load().(*logging.Logger).Op("confirm").Errorf("couldn't read the answer: %v", err)
+			return false
 		}
 
 		response = strings.ToLower(strings.TrimSpace(response))
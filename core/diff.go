@@ -7,6 +7,7 @@ package core
 
 import (
 	"bytes"
+	"context"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -18,12 +19,12 @@ import (
 	"github.com/go-git/go-git/v5/utils/merkletrie/noder"
 )
 
-func (asd *AsdRepository) worktreeStatus(w *git.Worktree, commit plumbing.Hash) (git.Status, error) {
+func (asd *AsdRepository) worktreeStatus(ctx context.Context, w *git.Worktree, commit plumbing.Hash) (git.Status, error) {
 	r := asd.Repository
 
 	s := make(git.Status)
 
-	left, err := diffCommitWithStaging(w, r, commit, false)
+	left, err := diffCommitWithStaging(ctx, w, r, commit, false)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +48,7 @@ func (asd *AsdRepository) worktreeStatus(w *git.Worktree, commit plumbing.Hash)
 		}
 	}
 
-	right, err := diffStagingWithWorktree(w, r, false)
+	right, err := diffStagingWithWorktree(ctx, w, r, false)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +87,7 @@ func nameFromAction(ch *merkletrie.Change) string {
 	return name
 }
 
-func diffStagingWithWorktree(w *git.Worktree, r *git.Repository, reverse bool) (merkletrie.Changes, error) {
+func diffStagingWithWorktree(ctx context.Context, w *git.Worktree, r *git.Repository, reverse bool) (merkletrie.Changes, error) {
 	idx, err := r.Storer.Index()
 	if err != nil {
 		return nil, err
@@ -102,9 +103,9 @@ func diffStagingWithWorktree(w *git.Worktree, r *git.Repository, reverse bool) (
 
 	var c merkletrie.Changes
 	if reverse {
-		c, err = merkletrie.DiffTree(to, from, diffTreeIsEquals)
+		c, err = merkletrie.DiffTreeContext(ctx, to, from, diffTreeIsEquals)
 	} else {
-		c, err = merkletrie.DiffTree(from, to, diffTreeIsEquals)
+		c, err = merkletrie.DiffTreeContext(ctx, from, to, diffTreeIsEquals)
 	}
 
 	if err != nil {
@@ -114,7 +115,7 @@ func diffStagingWithWorktree(w *git.Worktree, r *git.Repository, reverse bool) (
 	return excludeIgnoredChanges(w, c), nil
 }
 
-func diffCommitWithStaging(w *git.Worktree, r *git.Repository, commit plumbing.Hash, reverse bool) (merkletrie.Changes, error) {
+func diffCommitWithStaging(ctx context.Context, w *git.Worktree, r *git.Repository, commit plumbing.Hash, reverse bool) (merkletrie.Changes, error) {
 	var t *object.Tree
 	if !commit.IsZero() {
 		c, err := r.CommitObject(commit)
@@ -128,10 +129,10 @@ func diffCommitWithStaging(w *git.Worktree, r *git.Repository, commit plumbing.H
 		}
 	}
 
-	return diffTreeWithStaging(w, r, t, reverse)
+	return diffTreeWithStaging(ctx, w, r, t, reverse)
 }
 
-func diffTreeWithStaging(w *git.Worktree, r *git.Repository, t *object.Tree, reverse bool) (merkletrie.Changes, error) {
+func diffTreeWithStaging(ctx context.Context, w *git.Worktree, r *git.Repository, t *object.Tree, reverse bool) (merkletrie.Changes, error) {
 	var from noder.Noder
 	if t != nil {
 		from = object.NewTreeRootNode(t)
@@ -145,10 +146,10 @@ func diffTreeWithStaging(w *git.Worktree, r *git.Repository, t *object.Tree, rev
 	to := mindex.NewRootNode(idx)
 
 	if reverse {
-		return merkletrie.DiffTree(to, from, diffTreeIsEquals)
+		return merkletrie.DiffTreeContext(ctx, to, from, diffTreeIsEquals)
 	}
 
-	return merkletrie.DiffTree(from, to, diffTreeIsEquals)
+	return merkletrie.DiffTreeContext(ctx, from, to, diffTreeIsEquals)
 }
 
 var emptyNoderHash = make([]byte, 24)
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ExportFormat is an archive format that checkpoints can be exported to
+type ExportFormat string
+
+const (
+	ExportTar   ExportFormat = "tar"
+	ExportTarGz ExportFormat = "tar.gz"
+	ExportZip   ExportFormat = "zip"
+)
+
+var (
+	ErrUnknownExportFormat = errors.New("unknown archive format, use one of tar, tar.gz or zip")
+	ErrExportDirNotEmpty   = errors.New("the directory to export to already exists and isn't empty")
+)
+
+// ExportToDir writes the files of a checkpoint into dir, which is created if
+// needed and has to be empty. The repository isn't touched.
+func (asd *AsdRepository) ExportToDir(hash plumbing.Hash, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrExportDirNotEmpty, dir)
+	}
+
+	tree, err := asd.commitTree(hash)
+	if err != nil {
+		return err
+	}
+
+	fs := osfs.New(dir)
+	return tree.Files().ForEach(func(f *object.File) error {
+		return writeFile(fs, f)
+	})
+}
+
+// ExportArchive streams the files of a checkpoint to w as an archive. Files
+// get the time at which the checkpoint was saved.
+func (asd *AsdRepository) ExportArchive(hash plumbing.Hash, format ExportFormat, w io.Writer) error {
+	c, err := asd.Repository.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ExportTar:
+		return writeTar(tree, c, w)
+	case ExportTarGz:
+		gw := gzip.NewWriter(w)
+		if err = writeTar(tree, c, gw); err != nil {
+			return err
+		}
+
+		return gw.Close()
+	case ExportZip:
+		return writeZip(tree, c, w)
+	}
+
+	return fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+}
+
+func writeTar(tree *object.Tree, c *object.Commit, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		perm, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    f.Name,
+			Mode:    int64(perm.Perm()),
+			ModTime: c.Committer.When,
+		}
+
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = target
+			return tw.WriteHeader(header)
+		}
+
+		header.Typeflag = tar.TypeReg
+		header.Size = f.Size
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		return copyFile(tw, f)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func writeZip(tree *object.Tree, c *object.Commit, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		perm, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: c.Committer.When,
+		}
+		header.SetMode(perm)
+
+		// zip stores symlinks as files holding their target
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		return copyFile(fw, f)
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func copyFile(w io.Writer, f *object.File) error {
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
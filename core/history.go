@@ -0,0 +1,237 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// FileVersion is a version of a file, as saved by the checkpoint in which
+// it first appeared
+type FileVersion struct {
+	Checkpoint
+	// Blob is the hash of the contents, empty when the file was deleted
+	Blob    string `json:"blob,omitempty"`
+	Deleted bool   `json:"deleted"`
+	// Branch and UserCommit are where the checkpoint's chain was saved
+	Branch     string `json:"branch"`
+	UserCommit string `json:"user_commit"`
+	// Previous is the checkpoint with the version before this one, or the
+	// user commit for the oldest version
+	Previous string `json:"previous"`
+}
+
+// FileHistory returns the versions of the file at the slash separated path
+// saved in the autosaved chains of the user commits of the current branch,
+// newest first. Checkpoints that didn't change the file are skipped.
+func (asd *AsdRepository) FileHistory(name string) ([]FileVersion, error) {
+	r := asd.Repository
+	name = normalizePath(name)
+
+	head, err := r.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, ErrUserUnbornHead
+		}
+
+		return nil, err
+	}
+	branch := branchNameFromHead(head)
+
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make(map[plumbing.Hash][]*plumbing.Reference)
+	for _, ref := range refs {
+		_, hash, _ := parseAutosavedRefName(ref.Name())
+		chains[hash] = append(chains[hash], ref)
+	}
+
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	reachable, err := reachableCommits(r.Storer, headCommit, chains)
+	if err != nil {
+		return nil, err
+	}
+
+	// the checkpoints of the chains of reachable user commits, named by
+	// <n>/<m> when that is unambiguous and by their hashes otherwise
+	var checkpoints []FileVersion
+
+	// walked like in List, so that the specs are the same
+	left := len(reachable)
+	iter := object.NewCommitIterBSF(headCommit, nil, nil)
+	for i := 0; left > 0; i++ {
+		c, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		if !reachable[c.Hash] {
+			continue
+		}
+		commitRefs := chains[c.Hash]
+		left--
+
+		for _, ref := range commitRefs {
+			refBranch, _, _ := parseAutosavedRefName(ref.Name())
+
+			tip, err := r.CommitObject(ref.Hash())
+			if err != nil {
+				return nil, err
+			}
+
+			chain, err := autosaveChain(tip)
+			if err != nil {
+				return nil, err
+			}
+
+			// oldest first, so that checkpoints saved within the same
+			// second stay in order when sorting by time
+			for j := len(chain) - 1; j >= 0; j-- {
+				cp := chain[j]
+				v := FileVersion{
+					Checkpoint: Checkpoint{Commit: NewCommit(cp), Spec: cp.Hash.String()},
+					Branch:     refBranch,
+					UserCommit: c.Hash.String(),
+				}
+
+				// <n>/<m> finds the chain of the current branch first
+				if refBranch == branch || len(commitRefs) == 1 {
+					v.Spec = fmt.Sprintf("%d/%d", i, j+1)
+				}
+
+				if v.Blob, err = blobAt(cp, name); err != nil {
+					return nil, err
+				}
+
+				checkpoints = append(checkpoints, v)
+			}
+		}
+	}
+
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].When.Before(checkpoints[j].When)
+	})
+
+	var versions []FileVersion
+	for _, v := range checkpoints {
+		previous := ""
+		if len(versions) > 0 {
+			last := versions[len(versions)-1]
+			if last.Blob == v.Blob {
+				continue
+			}
+			previous = last.Hash
+		} else if v.Blob == "" {
+			// the file doesn't exist yet
+			continue
+		} else {
+			previous = v.UserCommit
+		}
+
+		v.Deleted = v.Blob == ""
+		v.Previous = previous
+		versions = append(versions, v)
+	}
+
+	// newest first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+// clockSkew is how much older than its child a commit can be, for
+// reachableCommits to still find it
+const clockSkew = 24 * time.Hour
+
+// reachableCommits returns which of the user commits of chains can be
+// reached from head. Newer commits are walked first, and the walk stops past
+// the oldest of them, so that chains left by rebased or deleted branches
+// don't make it walk the whole history.
+func reachableCommits(s storer.EncodedObjectStorer, head *object.Commit, chains map[plumbing.Hash][]*plumbing.Reference) (map[plumbing.Hash]bool, error) {
+	reachable := make(map[plumbing.Hash]bool)
+
+	candidates := make(map[plumbing.Hash]bool, len(chains))
+	var oldest time.Time
+	for hash := range chains {
+		c, err := object.GetCommit(s, hash)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		candidates[hash] = true
+		if oldest.IsZero() || c.Committer.When.Before(oldest) {
+			oldest = c.Committer.When
+		}
+	}
+
+	if len(candidates) == 0 {
+		return reachable, nil
+	}
+
+	stop := oldest.Add(-clockSkew)
+	iter := object.NewCommitIterCTime(head, nil, nil)
+	defer iter.Close()
+
+	for len(reachable) < len(candidates) {
+		c, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		if c.Committer.When.Before(stop) {
+			break
+		}
+
+		if candidates[c.Hash] {
+			reachable[c.Hash] = true
+		}
+	}
+
+	return reachable, nil
+}
+
+// blobAt returns the hash of the file at name in c, or "" if there is none
+func blobAt(c *object.Commit, name string) (string, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := tree.FindEntry(name)
+	if err != nil {
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return entry.Hash.String(), nil
+}
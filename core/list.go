@@ -0,0 +1,405 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	ErrBranchNotFound   = errors.New("branch not found")
+	ErrBranchAndAllList = errors.New("a branch can't be listed together with every chain")
+)
+
+// Commit is a commit as shown by `autosaved list`
+type Commit struct {
+	Hash        string    `json:"hash"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	When        time.Time `json:"when"`
+	Message     string    `json:"message"`
+}
+
+// Checkpoint is a save made by autosaved on top of a user commit
+type Checkpoint struct {
+	Commit
+	// Spec names the checkpoint for ResolveCheckpoint, as <n>/<m>, or as its
+	// hash when <n>/<m> would name a checkpoint of another branch's chain
+	Spec string `json:"spec"`
+	// Stats is only set with ListOptions.Stats
+	Stats *Stats `json:"stats,omitempty"`
+}
+
+// ListEntry is a commit made by the user, with the checkpoints saved on top
+// of it
+type ListEntry struct {
+	Commit
+	// Index is the position of the commit from the tip of the listed
+	// branch, starting at 0. With ListOptions.All, it is the position in
+	// the list.
+	Index int `json:"index"`
+	// Branch is the branch the checkpoints were saved on
+	Branch string `json:"branch,omitempty"`
+	// Ref is the autosaved ref holding the checkpoints, if there is one
+	Ref string `json:"ref,omitempty"`
+	// Checkpoints are the latest checkpoints, newest first
+	Checkpoints []Checkpoint `json:"checkpoints"`
+	// MoreCheckpoints is set when there are older checkpoints than the ones
+	// listed
+	MoreCheckpoints bool `json:"more_checkpoints"`
+}
+
+// ListOptions choose what List returns. The zero value lists every commit of
+// the current branch, without any of their checkpoints.
+type ListOptions struct {
+	// Limit is the most user commits listed, 0 doesn't limit them
+	Limit int
+	// CheckpointLimit is the most checkpoints listed per user commit
+	CheckpointLimit int
+
+	// Since and Until, when set, only keep what was committed in between
+	Since time.Time
+	Until time.Time
+	// Paths only keep what changed one of these paths, given like to diff
+	Paths []string
+
+	// Branch is listed instead of the current branch
+	Branch string
+	// All lists every autosaved chain, newest first, whether its user
+	// commit can be reached from a branch or not
+	All bool
+
+	// Stats adds the files changed by each checkpoint
+	Stats bool
+}
+
+func (opts ListOptions) filtered() bool {
+	return !opts.Since.IsZero() || !opts.Until.IsZero() || len(opts.Paths) > 0
+}
+
+// matches reports whether c was committed in the time range and changed one
+// of the paths
+func (opts ListOptions) matches(c *object.Commit) (bool, error) {
+	when := c.Committer.When
+	if !opts.Since.IsZero() && when.Before(opts.Since) {
+		return false, nil
+	}
+	if !opts.Until.IsZero() && when.After(opts.Until) {
+		return false, nil
+	}
+
+	return changesPaths(c, opts.Paths)
+}
+
+// NewCommit describes c for listing
+func NewCommit(c *object.Commit) Commit {
+	return Commit{
+		Hash:        c.Hash.String(),
+		AuthorName:  c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		When:        c.Author.When,
+		Message:     c.Message,
+	}
+}
+
+// List returns the commits of the user starting from the tip of a branch,
+// each with the latest checkpoints saved on top of it. When filtering by
+// time or paths, a commit is listed if it matches or if any of its
+// checkpoints do, and only the matching checkpoints are listed.
+func (asd *AsdRepository) List(opts ListOptions) ([]ListEntry, error) {
+	if opts.All {
+		if opts.Branch != "" {
+			return nil, ErrBranchAndAllList
+		}
+
+		return asd.listAll(opts)
+	}
+
+	r := asd.Repository
+
+	head, err := r.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, ErrUserUnbornHead
+		}
+
+		return nil, err
+	}
+
+	branch := branchNameFromHead(head)
+	current := branch
+	tip := head.Hash()
+	// <n>/<m> counts from HEAD, other branches need the commit's hash
+	numbered := true
+
+	if opts.Branch != "" && opts.Branch != branch {
+		ref, err := r.Reference(plumbing.NewBranchReferenceName(opts.Branch), true)
+		if err != nil {
+			if errors.Is(err, plumbing.ErrReferenceNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, opts.Branch)
+			}
+
+			return nil, err
+		}
+
+		branch = opts.Branch
+		tip = ref.Hash()
+		numbered = false
+	}
+
+	userCommit, err := r.CommitObject(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := chainRefsByCommit(r, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the chains that <n>/<m> specs resolve to
+	serial, err := chainRefsByCommit(r, current)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ListEntry
+
+	iter := object.NewCommitIterBSF(userCommit, nil, nil)
+	for i := 0; opts.Limit <= 0 || len(entries) < opts.Limit; i++ {
+		c, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		commitSpec := c.Hash.String()[:7]
+		if numbered {
+			commitSpec = fmt.Sprint(i)
+		}
+		if !sameRef(chains[c.Hash], serial[c.Hash]) {
+			commitSpec = ""
+		}
+
+		entry, ok, err := asd.listEntry(c, chains[c.Hash], commitSpec, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			entry.Index = i
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// listAll lists every autosaved chain, newest first
+func (asd *AsdRepository) listAll(opts ListOptions) ([]ListEntry, error) {
+	r := asd.Repository
+
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type chain struct {
+		ref *plumbing.Reference
+		tip *object.Commit
+	}
+
+	chains := make([]chain, 0, len(refs))
+	for _, ref := range refs {
+		tip, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		chains = append(chains, chain{ref, tip})
+	}
+
+	sort.SliceStable(chains, func(i, j int) bool {
+		return chains[i].tip.Committer.When.After(chains[j].tip.Committer.When)
+	})
+
+	current := ""
+	if head, err := r.Head(); err == nil {
+		current = branchNameFromHead(head)
+	}
+
+	// the chains that <n>/<m> specs resolve to
+	serial, err := chainRefsByCommit(r, current)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ListEntry
+	for _, ch := range chains {
+		if opts.Limit > 0 && len(entries) >= opts.Limit {
+			break
+		}
+
+		_, hash, _ := parseAutosavedRefName(ch.ref.Name())
+		c, err := r.CommitObject(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		commitSpec := hash.String()[:7]
+		if !sameRef(ch.ref, serial[hash]) {
+			commitSpec = ""
+		}
+
+		entry, ok, err := asd.listEntry(c, ch.ref, commitSpec, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			entry.Index = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// listEntry describes the user commit c with the checkpoints of the chain
+// at ref, which may be nil. The checkpoints are named <commitSpec>/<m>, or by
+// their hashes when commitSpec is empty. ok is false when neither c nor its
+// checkpoints match the filters of opts.
+func (asd *AsdRepository) listEntry(c *object.Commit, ref *plumbing.Reference, commitSpec string, opts ListOptions) (ListEntry, bool, error) {
+	r := asd.Repository
+	entry := ListEntry{Commit: NewCommit(c), Checkpoints: []Checkpoint{}}
+
+	ok, err := opts.matches(c)
+	if err != nil {
+		return entry, false, err
+	}
+
+	if ref == nil {
+		return entry, ok, nil
+	}
+
+	entry.Ref = ref.Name().String()
+	entry.Branch, _, _ = parseAutosavedRefName(ref.Name())
+
+	tip, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return entry, false, err
+	}
+
+	chain, err := autosaveChain(tip)
+	if err != nil {
+		return entry, false, err
+	}
+
+	for j, asdCommit := range chain {
+		matches, err := opts.matches(asdCommit)
+		if err != nil {
+			return entry, false, err
+		}
+		if !matches {
+			continue
+		}
+
+		if len(entry.Checkpoints) == opts.CheckpointLimit {
+			// if there are more...
+			entry.MoreCheckpoints = true
+			break
+		}
+
+		checkpoint := Checkpoint{
+			Commit: NewCommit(asdCommit),
+			Spec:   asdCommit.Hash.String(),
+		}
+		if commitSpec != "" {
+			checkpoint.Spec = fmt.Sprintf("%s/%d", commitSpec, j+1)
+		}
+
+		if opts.Stats {
+			if checkpoint.Stats, err = asd.CheckpointStats(asdCommit); err != nil {
+				return entry, false, err
+			}
+		}
+
+		entry.Checkpoints = append(entry.Checkpoints, checkpoint)
+	}
+
+	if !opts.filtered() {
+		return entry, true, nil
+	}
+
+	return entry, ok || len(entry.Checkpoints) > 0 || entry.MoreCheckpoints, nil
+}
+
+// chainRefsByCommit returns the autosaved refs by the user commit they were
+// saved on. The ref of the given branch is preferred when a commit has
+// several, like getAutosavedBranchRefForCommit does.
+func chainRefsByCommit(r *git.Repository, branch string) (map[plumbing.Hash]*plumbing.Reference, error) {
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make(map[plumbing.Hash]*plumbing.Reference, len(refs))
+	for _, ref := range refs {
+		refBranch, hash, _ := parseAutosavedRefName(ref.Name())
+		if _, ok := chains[hash]; !ok || refBranch == branch {
+			chains[hash] = ref
+		}
+	}
+
+	return chains, nil
+}
+
+// sameRef reports whether a and b are the same ref, or both nil
+func sameRef(a, b *plumbing.Reference) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Name() == b.Name()
+}
+
+// changesPaths reports whether c changed any of the paths from its first
+// parent. It is always true without paths.
+func changesPaths(c *object.Commit, paths []string) (bool, error) {
+	if len(paths) == 0 {
+		return true, nil
+	}
+
+	to, err := c.Tree()
+	if err != nil {
+		return false, err
+	}
+
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return false, err
+		}
+
+		if from, err = parent.Tree(); err != nil {
+			return false, err
+		}
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return false, err
+	}
+
+	return len(filterChanges(changes, paths)) > 0, nil
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"os"
+	"sync/atomic"
+
+	"github.com/nikochiko/autosaved/logging"
+)
+
+// logger holds the *logging.Logger used by the package
+var logger atomic.Value
+
+func init() {
+	logger.Store(logging.New(os.Stderr, logging.LevelInfo, logging.FormatLogfmt))
+}
+
+// SetLogger makes the package log through l
+func SetLogger(l *logging.Logger) {
+	logger.Store(l)
+}
+
+// log returns a logger for lines about op in the repository
+func (asd *AsdRepository) log(op string) *logging.Logger {
+	l := logger.Load().(*logging.Logger).Op(op)
+
+	if w, err := asd.Repository.Worktree(); err == nil {
+		l = l.Repo(w.Filesystem.Root())
+	}
+
+	return l
+}
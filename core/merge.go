@@ -0,0 +1,172 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// mergeHunk replaces the base lines [start, end) with lines
+type mergeHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// merge3 merges the changes made to base in ours and in theirs, line by line.
+// Where both sides changed the same lines differently, both versions are
+// written between conflict markers and conflict is set.
+func merge3(base, ours, theirs, oursLabel, theirsLabel string) (merged string, conflict bool) {
+	baseLines := splitLines(base)
+	oursHunks := lineHunks(base, ours)
+	theirsHunks := lineHunks(base, theirs)
+
+	var out strings.Builder
+	pos := 0
+	i, j := 0, 0
+	for i < len(oursHunks) || j < len(theirsHunks) {
+		// start a group with the earliest hunk, then add every hunk from
+		// either side that overlaps or touches the group
+		var oursGroup, theirsGroup []mergeHunk
+		var start, end int
+		if j >= len(theirsHunks) || (i < len(oursHunks) && oursHunks[i].start <= theirsHunks[j].start) {
+			start, end = oursHunks[i].start, oursHunks[i].end
+			oursGroup = append(oursGroup, oursHunks[i])
+			i++
+		} else {
+			start, end = theirsHunks[j].start, theirsHunks[j].end
+			theirsGroup = append(theirsGroup, theirsHunks[j])
+			j++
+		}
+
+		for {
+			if i < len(oursHunks) && oursHunks[i].start <= end {
+				oursGroup = append(oursGroup, oursHunks[i])
+				if oursHunks[i].end > end {
+					end = oursHunks[i].end
+				}
+				i++
+				continue
+			}
+
+			if j < len(theirsHunks) && theirsHunks[j].start <= end {
+				theirsGroup = append(theirsGroup, theirsHunks[j])
+				if theirsHunks[j].end > end {
+					end = theirsHunks[j].end
+				}
+				j++
+				continue
+			}
+
+			break
+		}
+
+		writeLines(&out, baseLines[pos:start])
+		pos = end
+
+		oursText := applyHunks(baseLines, start, end, oursGroup)
+		theirsText := applyHunks(baseLines, start, end, theirsGroup)
+
+		switch {
+		case len(theirsGroup) == 0:
+			out.WriteString(oursText)
+		case len(oursGroup) == 0 || oursText == theirsText:
+			out.WriteString(theirsText)
+		default:
+			conflict = true
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			out.WriteString(withTrailingNewline(oursText))
+			out.WriteString("=======\n")
+			out.WriteString(withTrailingNewline(theirsText))
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+	}
+
+	writeLines(&out, baseLines[pos:])
+
+	return out.String(), conflict
+}
+
+// lineHunks lists the changes going from base to other, in terms of base's
+// lines
+func lineHunks(base, other string) []mergeHunk {
+	var hunks []mergeHunk
+	var current *mergeHunk
+
+	pos := 0
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+
+		if d.Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+
+			pos += len(lines)
+			continue
+		}
+
+		if current == nil {
+			current = &mergeHunk{start: pos, end: pos}
+		}
+
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			current.end += len(lines)
+			pos += len(lines)
+		case diffmatchpatch.DiffInsert:
+			current.lines = append(current.lines, lines...)
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+
+	return hunks
+}
+
+// applyHunks returns base's lines [start, end) with the hunks applied
+func applyHunks(baseLines []string, start, end int, hunks []mergeHunk) string {
+	var out strings.Builder
+
+	pos := start
+	for _, h := range hunks {
+		writeLines(&out, baseLines[pos:h.start])
+		writeLines(&out, h.lines)
+		pos = h.end
+	}
+	writeLines(&out, baseLines[pos:end])
+
+	return out.String()
+}
+
+// splitLines splits s after every newline, keeping the newlines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+func withTrailingNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+
+	return s + "\n"
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DiffTarget is what a checkpoint gets compared against
+type DiffTarget int
+
+const (
+	// DiffWorktree compares with the files on disk, including untracked ones
+	DiffWorktree DiffTarget = iota
+	// DiffIndex compares with the staging area
+	DiffIndex
+	// DiffHead compares with the checked out commit
+	DiffHead
+)
+
+var ErrUnknownDiffTarget = errors.New("unknown diff target")
+
+// DiffCheckpoint returns the patch going from the checkpoint to the target,
+// limited to the given paths if there are any
+func (asd *AsdRepository) DiffCheckpoint(checkpoint plumbing.Hash, target DiffTarget, paths []string) (*object.Patch, error) {
+	from, err := asd.commitTree(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := asd.targetTree(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffTrees(from, to, paths)
+}
+
+// DiffCheckpoints returns the patch going from one checkpoint to another,
+// limited to the given paths if there are any
+func (asd *AsdRepository) DiffCheckpoints(from, to plumbing.Hash, paths []string) (*object.Patch, error) {
+	fromTree, err := asd.commitTree(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := asd.commitTree(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffTrees(fromTree, toTree, paths)
+}
+
+func diffTrees(from, to *object.Tree, paths []string) (*object.Patch, error) {
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterChanges(changes, paths).Patch()
+}
+
+func (asd *AsdRepository) commitTree(hash plumbing.Hash) (*object.Tree, error) {
+	c, err := asd.Repository.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Tree()
+}
+
+// targetTree returns the tree of the worktree, the index or HEAD. The trees
+// for the worktree and the index are only kept in memory.
+func (asd *AsdRepository) targetTree(target DiffTarget) (*object.Tree, error) {
+	r := asd.Repository
+
+	switch target {
+	case DiffWorktree:
+		w, err := r.Worktree()
+		if err != nil {
+			return nil, err
+		}
+
+		s := newOverlayStorer(r.Storer)
+		h, err := asd.snapshotWorktree(context.Background(), w, s)
+		if err != nil {
+			return nil, err
+		}
+
+		return object.GetTree(s, h)
+	case DiffIndex:
+		idx, err := r.Storer.Index()
+		if err != nil {
+			return nil, err
+		}
+
+		entries := make(map[string]snapshotEntry, len(idx.Entries))
+		for _, e := range idx.Entries {
+			entries[e.Name] = snapshotEntry{Mode: e.Mode, Hash: e.Hash}
+		}
+
+		s := newOverlayStorer(r.Storer)
+		h, err := writeTree(s, entries)
+		if err != nil {
+			return nil, err
+		}
+
+		return object.GetTree(s, h)
+	case DiffHead:
+		userCommit, err := asd.getLastUserCommitOnCurrentBranch()
+		if err != nil {
+			return nil, err
+		}
+
+		return userCommit.Tree()
+	}
+
+	return nil, ErrUnknownDiffTarget
+}
+
+func filterChanges(changes object.Changes, paths []string) object.Changes {
+	if len(paths) == 0 {
+		return changes
+	}
+
+	var filtered object.Changes
+	for _, ch := range changes {
+		if matchesPaths(ch.From.Name, paths) || matchesPaths(ch.To.Name, paths) {
+			filtered = append(filtered, ch)
+		}
+	}
+
+	return filtered
+}
+
+// matchesPaths reports whether the slash separated path name is one of the
+// given paths, is inside one of them, or matches one of them as a glob
+func matchesPaths(name string, paths []string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, p := range paths {
+		p = normalizePath(p)
+		if p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+
+		// like in .gitignore, a pattern without a slash matches at any depth
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// normalizePath turns a path given by the user into the slash separated form
+// used inside of trees
+func normalizePath(p string) string {
+	return strings.TrimSuffix(path.Clean(filepath.ToSlash(p)), "/")
+}
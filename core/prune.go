@@ -0,0 +1,267 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+var ErrRetentionTierInvalid = errors.New("retention tiers need a positive `within` and a non-negative `every`")
+
+// RetentionTier thins out checkpoints younger than Within so that only the
+// newest checkpoint of every Every long period is kept. An Every of 0 keeps
+// all of them.
+type RetentionTier struct {
+	Within time.Duration
+	Every  time.Duration
+}
+
+// RetentionPolicy decides which checkpoints are kept by Prune. The zero value
+// keeps everything.
+type RetentionPolicy struct {
+	// MaxCount is the maximum number of checkpoints kept per user commit
+	MaxCount int
+	// MaxAge drops checkpoints older than this
+	MaxAge time.Duration
+	// Tiers are applied from the shortest Within to the longest. When tiers
+	// are set, checkpoints older than all of them are dropped.
+	Tiers []RetentionTier
+}
+
+// IsZero reports whether the policy keeps every checkpoint
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxCount <= 0 && p.MaxAge <= 0 && len(p.Tiers) == 0
+}
+
+// Validate checks the policy for values that don't make sense
+func (p RetentionPolicy) Validate() error {
+	for _, t := range p.Tiers {
+		if t.Within <= 0 || t.Every < 0 {
+			return ErrRetentionTierInvalid
+		}
+	}
+
+	return nil
+}
+
+// PruneResult is what Prune did (or would do) to one autosaved chain
+type PruneResult struct {
+	Ref     plumbing.ReferenceName
+	Kept    []*object.Commit
+	Dropped []*object.Commit
+	// Deleted is set when no checkpoint is kept and the ref is removed
+	Deleted bool
+}
+
+// PruneOptions configure Prune
+type PruneOptions struct {
+	// DryRun computes the results without writing anything
+	DryRun bool
+}
+
+// SetRetentionPolicy is the Setter method for the retention configuration
+func (asd *AsdRepository) SetRetentionPolicy(p RetentionPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	asd.retention = p
+	return nil
+}
+
+// Prune applies the retention policy to every autosaved chain of the
+// repository. Kept checkpoints are re-parented onto each other, so that
+// dropped ones become unreachable, and refs left without checkpoints are
+// deleted. Re-parenting gives a new hash to every checkpoint above a dropped
+// one. Checkpoints in the restore journal are pinned: they and the ones
+// before them are always kept, with the same hashes.
+func (asd *AsdRepository) Prune(opts PruneOptions) ([]PruneResult, error) {
+	if asd.retention.IsZero() {
+		return nil, nil
+	}
+
+	r := asd.Repository
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := asd.pinnedCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var results []PruneResult
+	for _, ref := range refs {
+		tip, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return results, err
+		}
+
+		chain, err := autosaveChain(tip)
+		if err != nil {
+			return results, err
+		}
+
+		if len(chain) == 0 || chain[len(chain)-1].NumParents() == 0 {
+			continue
+		}
+
+		kept, dropped := asd.retention.apply(chain, now)
+		kept, dropped = keepPinned(chain, kept, pinned)
+		if len(dropped) == 0 {
+			continue
+		}
+
+		result := PruneResult{Ref: ref.Name(), Kept: kept, Dropped: dropped, Deleted: len(kept) == 0}
+		results = append(results, result)
+
+		if opts.DryRun {
+			continue
+		}
+
+		if err = asd.rewriteChain(ref, chain, kept); err != nil {
+			return results, err
+		}
+
+		// the kept checkpoints may have been rewritten onto new parents, so
+		// their stats are computed again too
+		asd.removeCachedStats(chain)
+	}
+
+	return results, nil
+}
+
+// apply splits a chain (newest first) into the checkpoints to keep and the
+// ones to drop
+func (p RetentionPolicy) apply(chain []*object.Commit, now time.Time) (kept, dropped []*object.Commit) {
+	tiers := make([]RetentionTier, len(p.Tiers))
+	copy(tiers, p.Tiers)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Within < tiers[j].Within })
+
+	// periods of each tier which already have their newest checkpoint kept
+	seen := make([]map[time.Time]bool, len(tiers))
+	for i := range seen {
+		seen[i] = make(map[time.Time]bool)
+	}
+
+	for _, c := range chain {
+		when := c.Committer.When
+		age := now.Sub(when)
+
+		keep := p.MaxAge <= 0 || age <= p.MaxAge
+		if keep && len(tiers) > 0 {
+			keep = false
+			for i, t := range tiers {
+				if age > t.Within {
+					continue
+				}
+
+				if t.Every <= 0 {
+					keep = true
+				} else if period := when.Truncate(t.Every); !seen[i][period] {
+					seen[i][period] = true
+					keep = true
+				}
+
+				break
+			}
+		}
+
+		if keep && p.MaxCount > 0 && len(kept) >= p.MaxCount {
+			keep = false
+		}
+
+		if keep {
+			kept = append(kept, c)
+		} else {
+			dropped = append(dropped, c)
+		}
+	}
+
+	return kept, dropped
+}
+
+// keepPinned adds the pinned checkpoints of a chain (newest first) and every
+// checkpoint before them to kept, as dropping one of those would rewrite the
+// pinned ones. It returns kept and dropped again, in chain order.
+func keepPinned(chain, kept []*object.Commit, pinned map[plumbing.Hash]bool) ([]*object.Commit, []*object.Commit) {
+	keep := make(map[plumbing.Hash]bool, len(chain))
+	for _, c := range kept {
+		keep[c.Hash] = true
+	}
+
+	below := false
+	var newKept, dropped []*object.Commit
+	for _, c := range chain {
+		below = below || pinned[c.Hash]
+		if below || keep[c.Hash] {
+			newKept = append(newKept, c)
+		} else {
+			dropped = append(dropped, c)
+		}
+	}
+
+	return newKept, dropped
+}
+
+// rewriteChain points ref at a chain made only of the kept checkpoints. The
+// oldest checkpoints keep their hashes for as long as nothing below them was
+// dropped.
+func (asd *AsdRepository) rewriteChain(ref *plumbing.Reference, chain, kept []*object.Commit) error {
+	r := asd.Repository
+
+	// the user commit that the chain started from
+	base := chain[len(chain)-1].ParentHashes[0]
+
+	parent := base
+	for i := len(kept) - 1; i >= 0; i-- {
+		c := kept[i]
+		if c.ParentHashes[0] == parent {
+			parent = c.Hash
+			continue
+		}
+
+		rewritten := object.Commit{
+			Author:       c.Author,
+			Committer:    c.Committer,
+			Message:      c.Message,
+			TreeHash:     c.TreeHash,
+			ParentHashes: []plumbing.Hash{parent},
+		}
+
+		obj := r.Storer.NewEncodedObject()
+		if err := rewritten.Encode(obj); err != nil {
+			return err
+		}
+
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			return err
+		}
+
+		parent = h
+	}
+
+	if parent == base {
+		// nothing is kept, make sure nobody saved in the meantime
+		current, err := r.Storer.Reference(ref.Name())
+		if err != nil {
+			return err
+		}
+
+		if current.Hash() != ref.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+
+		return r.Storer.RemoveReference(ref.Name())
+	}
+
+	return r.Storer.CheckAndSetReference(plumbing.NewHashReference(ref.Name(), parent), ref)
+}
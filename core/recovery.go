@@ -0,0 +1,233 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var ErrOnAutosavedBranch = errors.New("HEAD is on a branch or checkpoint of autosaved, not saving on top of it")
+
+// operationsDir holds the journal of operations in progress, one file each,
+// inside of asdDir
+const operationsDir = "operations"
+
+// operationEntry is written when an operation starts and removed when it
+// ends, so that one left behind shows that it was interrupted
+type operationEntry struct {
+	Op      string    `json:"op"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// Head is the ref HEAD pointed to when the operation started
+	Head string `json:"head"`
+}
+
+// Recovery describes what Recover did, and what it couldn't fix
+type Recovery struct {
+	// Actions are the things that were fixed
+	Actions []string
+	// Problem is set when the repository is left in a state autosaved
+	// won't save in, and that needs the user to fix it
+	Problem string
+}
+
+// beginOperation records that op started, and returns the function that
+// records that it ended
+func (asd *AsdRepository) beginOperation(op string, head *plumbing.Reference) (func(), error) {
+	fs, err := asd.gitDirFilesystem()
+	if errors.Is(err, ErrNoGitDir) {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join(asdDir, operationsDir)
+	if err = fs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entry := operationEntry{Op: op, PID: os.Getpid(), Started: time.Now(), Head: head.Name().String()}
+	name := path.Join(dir, fmt.Sprintf("%d-%s-%d.json", entry.PID, op, entry.Started.UnixNano()))
+
+	f, err := fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		fs.Remove(name)
+		return nil, err
+	}
+
+	if err = f.Close(); err != nil {
+		fs.Remove(name)
+		return nil, err
+	}
+
+	return func() {
+		fs.Remove(name)
+	}, nil
+}
+
+// Recover cleans up after operations that were interrupted, and moves HEAD
+// back to the user's branch if an older version of autosaved was killed while
+// HEAD was on one of its _asd_ branches. The index and the worktree are never
+// touched. Operations of this process are taken as interrupted, so it must
+// not run alongside them.
+func (asd *AsdRepository) Recover() (*Recovery, error) {
+	rec := &Recovery{}
+
+	if err := asd.recoverOperations(rec); err != nil {
+		return rec, err
+	}
+
+	if err := asd.recoverHead(rec); err != nil {
+		return rec, err
+	}
+
+	return rec, nil
+}
+
+func (asd *AsdRepository) recoverOperations(rec *Recovery) error {
+	fs, err := asd.gitDirFilesystem()
+	if errors.Is(err, ErrNoGitDir) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	dir := path.Join(asdDir, operationsDir)
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+
+		var entry operationEntry
+		f, err := fs.Open(name)
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(f).Decode(&entry)
+		f.Close()
+
+		if err == nil && entry.PID != os.Getpid() && processAlive(entry.PID) {
+			// still running in another process
+			continue
+		}
+
+		if err = fs.Remove(name); err != nil {
+			return err
+		}
+
+		if entry.Op == "" {
+			rec.Actions = append(rec.Actions, fmt.Sprintf("removed the unreadable journal entry %s", info.Name()))
+			continue
+		}
+
+		// saves only write objects and move a ref with a compare-and-swap,
+		// so an interrupted one leaves nothing else to undo
+		rec.Actions = append(rec.Actions, fmt.Sprintf("cleared an interrupted %s from %s", entry.Op, entry.Started.Local().Format("2006-01-02 15:04:05")))
+	}
+
+	return nil
+}
+
+func (asd *AsdRepository) recoverHead(rec *Recovery) error {
+	r := asd.Repository
+
+	head, err := r.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !head.Name().IsBranch() {
+		c, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+
+		if c.Committer.Name == autosavedSignatureName {
+			rec.Problem = fmt.Sprintf("HEAD is detached at checkpoint %s, check out a branch to start autosaving again", head.Hash().String()[:7])
+		}
+
+		return nil
+	}
+
+	legacy := head.Name().Short()
+	if !strings.HasPrefix(legacy, AutosavedBranchPrefix) {
+		return nil
+	}
+
+	// the legacy branch was made from the tip of the user's branch, which
+	// is named after it
+	hashString := strings.TrimPrefix(legacy, AutosavedBranchPrefix)
+
+	branches, err := userBranches(r, nil)
+	if err != nil {
+		return err
+	}
+
+	var candidates []plumbing.ReferenceName
+	for _, b := range branches {
+		if b.Hash().String() == hashString {
+			candidates = append(candidates, b.Name())
+		}
+	}
+
+	if len(candidates) != 1 {
+		rec.Problem = fmt.Sprintf("HEAD is on autosaved's branch %s and it isn't clear which branch it came from, check out your branch to start autosaving again", legacy)
+		return nil
+	}
+
+	err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, candidates[0]))
+	if err != nil {
+		return err
+	}
+
+	rec.Actions = append(rec.Actions, fmt.Sprintf("moved HEAD from %s back to %s, the index and worktree were left as they are", legacy, candidates[0].Short()))
+	return nil
+}
+
+// onAutosavedBranch reports whether HEAD is on a legacy _asd_ branch or at a
+// checkpoint, where saving would make checkpoints of checkpoints
+func onAutosavedBranch(head *plumbing.Reference, userCommitCommitter string) bool {
+	if head.Name().IsBranch() && strings.HasPrefix(head.Name().Short(), AutosavedBranchPrefix) {
+		return true
+	}
+
+	return userCommitCommitter == autosavedSignatureName
+}
+
+// processAlive reports whether a process with the pid is running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,276 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// AutosavedRefPrefix is the namespace under which checkpoints are stored, as
+// refs/autosaved/<branch>/<user-commit>. Refs outside of refs/heads/ don't
+// show up in `git branch` and aren't pushed by `git push --all`.
+const AutosavedRefPrefix = "refs/autosaved/"
+
+// detachedBranchName is used in place of the branch name when HEAD is
+// detached, or when a legacy branch can't be traced back to any branch
+const detachedBranchName = "HEAD"
+
+var ErrCheckpointNotFound = errors.New("no autosaved checkpoint found with this hash")
+
+func getAutosavedRefName(branch string, commitHash plumbing.Hash) plumbing.ReferenceName {
+	return plumbing.ReferenceName(AutosavedRefPrefix + branch + "/" + commitHash.String())
+}
+
+// parseAutosavedRefName splits a ref name from the autosaved namespace into
+// the branch and the user commit it belongs to
+func parseAutosavedRefName(name plumbing.ReferenceName) (string, plumbing.Hash, bool) {
+	s := string(name)
+	if !strings.HasPrefix(s, AutosavedRefPrefix) {
+		return "", plumbing.ZeroHash, false
+	}
+
+	s = strings.TrimPrefix(s, AutosavedRefPrefix)
+	i := strings.LastIndex(s, "/")
+	if i <= 0 || !plumbing.IsHash(s[i+1:]) {
+		return "", plumbing.ZeroHash, false
+	}
+
+	return s[:i], plumbing.NewHash(s[i+1:]), true
+}
+
+// branchNameFromHead returns the short name of the checked out branch
+func branchNameFromHead(head *plumbing.Reference) string {
+	if head.Name().IsBranch() {
+		return head.Name().Short()
+	}
+
+	return detachedBranchName
+}
+
+// autosavedRefs returns all the refs in the autosaved namespace
+func autosavedRefs(r *git.Repository) ([]*plumbing.Reference, error) {
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if _, _, ok := parseAutosavedRefName(ref.Name()); ok {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// autosaveChain returns the autosaved commits reachable from c, newest first,
+// stopping at the first commit which wasn't made by autosaved
+func autosaveChain(c *object.Commit) ([]*object.Commit, error) {
+	var chain []*object.Commit
+	for c.Committer.Name == autosavedSignatureName {
+		chain = append(chain, c)
+
+		if c.NumParents() == 0 {
+			break
+		}
+
+		var err error
+		c, err = c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chain, nil
+}
+
+// findCheckpoint looks for the checkpoint with the given hash in all of the
+// autosaved chains, and returns it along with the ref of its chain
+func findCheckpoint(r *git.Repository, hash plumbing.Hash) (*object.Commit, *plumbing.Reference, error) {
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ref := range refs {
+		tip, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		chain, err := autosaveChain(tip)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, c := range chain {
+			if c.Hash == hash {
+				return c, ref, nil
+			}
+		}
+	}
+
+	return nil, nil, ErrCheckpointNotFound
+}
+
+// LegacyMigration describes a legacy _asd_ branch moved by
+// MigrateLegacyBranches
+type LegacyMigration struct {
+	From    plumbing.ReferenceName
+	To      plumbing.ReferenceName
+	Skipped string
+}
+
+// MigrateLegacyBranches moves the _asd_<commit> branches written by older
+// versions of autosaved into the refs/autosaved/ namespace. Each chain is
+// filed under the first branch that contains its user commit, preferring the
+// checked out one.
+func (asd *AsdRepository) MigrateLegacyBranches() ([]LegacyMigration, error) {
+	r := asd.Repository
+
+	head, err := r.Head()
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	branches, err := userBranches(r, head)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := r.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	var legacy []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().Short(), AutosavedBranchPrefix) {
+			legacy = append(legacy, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []LegacyMigration
+	for _, ref := range legacy {
+		m := LegacyMigration{From: ref.Name()}
+
+		hashString := strings.TrimPrefix(ref.Name().Short(), AutosavedBranchPrefix)
+		if !plumbing.IsHash(hashString) {
+			m.Skipped = "branch name doesn't end with a commit hash"
+			migrations = append(migrations, m)
+			continue
+		}
+
+		if head != nil && head.Name() == ref.Name() {
+			m.Skipped = "branch is checked out"
+			migrations = append(migrations, m)
+			continue
+		}
+
+		userCommitHash := plumbing.NewHash(hashString)
+		branch, err := findBranchContaining(r, branches, userCommitHash)
+		if err != nil {
+			return migrations, err
+		}
+
+		m.To = getAutosavedRefName(branch, userCommitHash)
+		if _, err = r.Storer.Reference(m.To); err == nil {
+			m.Skipped = "a checkpoint ref for this commit already exists"
+			migrations = append(migrations, m)
+			continue
+		}
+
+		err = r.Storer.SetReference(plumbing.NewHashReference(m.To, ref.Hash()))
+		if err != nil {
+			return migrations, err
+		}
+
+		err = r.Storer.RemoveReference(ref.Name())
+		if err != nil {
+			return migrations, err
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+// userBranches returns the branches which aren't legacy autosaved branches,
+// with the checked out branch first
+func userBranches(r *git.Repository, head *plumbing.Reference) ([]*plumbing.Reference, error) {
+	iter, err := r.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !strings.HasPrefix(ref.Name().Short(), AutosavedBranchPrefix) {
+			branches = append(branches, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	isHead := func(ref *plumbing.Reference) bool {
+		return head != nil && ref.Name() == head.Name()
+	}
+
+	sort.Slice(branches, func(i, j int) bool {
+		if isHead(branches[i]) != isHead(branches[j]) {
+			return isHead(branches[i])
+		}
+
+		return branches[i].Name() < branches[j].Name()
+	})
+
+	return branches, nil
+}
+
+func findBranchContaining(r *git.Repository, branches []*plumbing.Reference, hash plumbing.Hash) (string, error) {
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return detachedBranchName, nil
+		}
+
+		return "", err
+	}
+
+	for _, branch := range branches {
+		tip, err := r.CommitObject(branch.Hash())
+		if err != nil {
+			return "", err
+		}
+
+		ok, err := c.IsAncestor(tip)
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			return branch.Name().Short(), nil
+		}
+	}
+
+	return detachedBranchName, nil
+}
@@ -0,0 +1,393 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var (
+	ErrCheckpointSpecInvalid = errors.New("couldn't find a checkpoint or commit matching this")
+	ErrAmbiguousCheckpoint   = errors.New("more than one checkpoint or commit matches this")
+	ErrNotACheckpoint        = errors.New("this is a commit, not a checkpoint saved by autosaved")
+	ErrNoCheckpointBefore    = errors.New("no checkpoint was saved before this time")
+	ErrInvalidTime           = errors.New("couldn't read this as a time, use one like \"15 minutes ago\", \"yesterday\" or \"2026-10-17 14:00\"")
+)
+
+var (
+	nthLatestRegexp  = regexp.MustCompile(`^asd@\{(\d+)\}$`)
+	serialRegexp     = regexp.MustCompile(`^([^/]+)/(\d+)$`)
+	relativeRegexp   = regexp.MustCompile(`^(\d+|an?)\s+(second|minute|hour|day|week)s?\s+ago$`)
+	hashPrefixRegexp = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+)
+
+// minimumHashPrefix is the shortest abbreviated hash that is accepted, the
+// same as git's
+const minimumHashPrefix = 4
+
+// absoluteTimeLayouts are the formats accepted for absolute times, in the
+// local timezone unless the layout has one
+var absoluteTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ResolveCheckpoint finds the checkpoint meant by spec. It understands:
+//   - full or abbreviated (at least 4 characters) checkpoint hashes
+//   - <n>/<m> as printed by `autosaved list`: the m-th autosave of the n-th
+//     commit in the list. <n> can also be a commit hash
+//   - asd@{n}: the n-th latest autosave on the current branch, asd@{0} is the
+//     latest one
+//   - relative or absolute times like "15 minutes ago", "yesterday" or
+//     "2026-10-17 14:00": the latest checkpoint saved at or before that time
+//     on the current branch
+//
+// Anything else is resolved like a git revision, so commits can be used too
+// where any tree will do. ResolveSavedCheckpoint only accepts checkpoints.
+func (asd *AsdRepository) ResolveCheckpoint(spec string) (*object.Commit, error) {
+	spec = strings.TrimSpace(spec)
+
+	if m := nthLatestRegexp.FindStringSubmatch(spec); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return asd.nthLatestCheckpoint(n)
+	}
+
+	if m := serialRegexp.FindStringSubmatch(spec); m != nil {
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, err
+		}
+
+		return asd.checkpointBySerial(m[1], n)
+	}
+
+	if t, ok := parseCheckpointTime(spec, time.Now()); ok {
+		return asd.checkpointAt(t)
+	}
+
+	r := asd.Repository
+
+	if hashPrefixRegexp.MatchString(spec) {
+		c, err := asd.checkpointByHashPrefix(spec)
+		if err == nil {
+			if other, ok := asd.otherRevision(spec, c.Hash); ok {
+				return nil, fmt.Errorf("%w: %q is checkpoint %s and also %s", ErrAmbiguousCheckpoint, spec, c.Hash.String()[:10], other.String()[:10])
+			}
+
+			return c, nil
+		}
+
+		if !errors.Is(err, ErrCheckpointNotFound) {
+			return nil, err
+		}
+	}
+	h, err := r.ResolveRevision(plumbing.Revision(spec))
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, fmt.Errorf("%w: %q", ErrCheckpointSpecInvalid, spec)
+		}
+
+		return nil, err
+	}
+
+	return r.CommitObject(*h)
+}
+
+// ResolveSavedCheckpoint is ResolveCheckpoint for when spec has to be a
+// checkpoint, like for restoring one. Specs that resolve to other commits are
+// rejected with ErrNotACheckpoint.
+func (asd *AsdRepository) ResolveSavedCheckpoint(spec string) (*object.Commit, error) {
+	c, err := asd.ResolveCheckpoint(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err = findCheckpoint(asd.Repository, c.Hash); err != nil {
+		if errors.Is(err, ErrCheckpointNotFound) {
+			return nil, fmt.Errorf("%w: %q is commit %s", ErrNotACheckpoint, spec, c.Hash.String()[:10])
+		}
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// otherRevision returns the commit that spec resolves to as a revision or as
+// the name of a branch or tag, when that isn't the checkpoint with hash
+func (asd *AsdRepository) otherRevision(spec string, hash plumbing.Hash) (plumbing.Hash, bool) {
+	r := asd.Repository
+
+	// a hash prefix wins over a ref of the same name, as in git
+	candidates := []plumbing.Revision{plumbing.Revision(spec)}
+	for _, rule := range plumbing.RefRevParseRules {
+		ref, err := storer.ResolveReference(r.Storer, plumbing.ReferenceName(fmt.Sprintf(rule, spec)))
+		if err == nil {
+			candidates = append(candidates, plumbing.Revision(ref.Name()))
+			break
+		}
+	}
+
+	for _, rev := range candidates {
+		h, err := r.ResolveRevision(rev)
+		if err == nil && *h != hash {
+			return *h, true
+		}
+	}
+
+	return plumbing.ZeroHash, false
+}
+
+// nthLatestCheckpoint returns the n-th latest checkpoint of the current
+// branch, counting from 0
+func (asd *AsdRepository) nthLatestCheckpoint(n int) (*object.Commit, error) {
+	checkpoints, err := asd.currentBranchCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	if n >= len(checkpoints) {
+		return nil, fmt.Errorf("%w: asd@{%d}, there are only %d checkpoints on this branch", ErrCheckpointSpecInvalid, n, len(checkpoints))
+	}
+
+	return checkpoints[n], nil
+}
+
+// checkpointBySerial returns the n-th autosave (counting from 1, the latest)
+// of a user commit. The commit is either its number in `autosaved list` or
+// a revision.
+func (asd *AsdRepository) checkpointBySerial(commitSpec string, n int) (*object.Commit, error) {
+	r := asd.Repository
+
+	var userCommit *object.Commit
+	if index, err := strconv.Atoi(commitSpec); err == nil && len(commitSpec) < minimumHashPrefix {
+		head, err := asd.getLastUserCommitOnCurrentBranch()
+		if err != nil {
+			return nil, err
+		}
+
+		// same order as in List
+		iter := object.NewCommitIterBSF(head, nil, nil)
+		for i := 0; i <= index; i++ {
+			userCommit, err = iter.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, fmt.Errorf("%w: there is no commit number %d", ErrCheckpointSpecInvalid, index)
+				}
+
+				return nil, err
+			}
+		}
+	} else {
+		h, err := r.ResolveRevision(plumbing.Revision(commitSpec))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrCheckpointSpecInvalid, commitSpec)
+		}
+
+		userCommit, err = r.CommitObject(*h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := getAutosavedBranchRefForCommit(r, branchNameFromHead(head), userCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	tip, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := autosaveChain(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 1 || n > len(chain) {
+		return nil, fmt.Errorf("%w: commit %s has %d autosaves", ErrCheckpointSpecInvalid, userCommit.Hash.String()[:7], len(chain))
+	}
+
+	return chain[n-1], nil
+}
+
+// checkpointAt returns the latest checkpoint on the current branch that was
+// saved at or before t
+func (asd *AsdRepository) checkpointAt(t time.Time) (*object.Commit, error) {
+	checkpoints, err := asd.currentBranchCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range checkpoints {
+		if !c.Committer.When.After(t) {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoCheckpointBefore, t.Format("2006-01-02 15:04:05"))
+}
+
+// checkpointByHashPrefix looks for the checkpoints whose hash starts with
+// prefix in all of the autosaved chains
+func (asd *AsdRepository) checkpointByHashPrefix(prefix string) (*object.Commit, error) {
+	r := asd.Repository
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[plumbing.Hash]*object.Commit)
+	for _, ref := range refs {
+		tip, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		chain, err := autosaveChain(tip)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chain {
+			if strings.HasPrefix(c.Hash.String(), prefix) {
+				matches[c.Hash] = c
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, ErrCheckpointNotFound
+	case 1:
+		for _, c := range matches {
+			return c, nil
+		}
+	}
+
+	var candidates []string
+	for h := range matches {
+		candidates = append(candidates, h.String()[:10])
+	}
+	sort.Strings(candidates)
+
+	return nil, fmt.Errorf("%w: %q could be any of %s", ErrAmbiguousCheckpoint, prefix, strings.Join(candidates, ", "))
+}
+
+// currentBranchCheckpoints returns all checkpoints saved on the checked out
+// branch, newest first
+func (asd *AsdRepository) currentBranchCheckpoints() ([]*object.Commit, error) {
+	r := asd.Repository
+
+	head, err := r.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, ErrUserUnbornHead
+		}
+
+		return nil, err
+	}
+
+	refs, err := autosavedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := branchNameFromHead(head)
+
+	var checkpoints []*object.Commit
+	for _, ref := range refs {
+		if refBranch, _, _ := parseAutosavedRefName(ref.Name()); refBranch != branch {
+			continue
+		}
+
+		tip, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		chain, err := autosaveChain(tip)
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoints = append(checkpoints, chain...)
+	}
+
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Committer.When.After(checkpoints[j].Committer.When)
+	})
+
+	return checkpoints, nil
+}
+
+// ParseTime reads a time given like to ResolveCheckpoint, relative to now
+func ParseTime(s string) (time.Time, error) {
+	t, ok := parseCheckpointTime(strings.TrimSpace(s), time.Now())
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, s)
+	}
+
+	return t, nil
+}
+
+// parseCheckpointTime parses relative times like "15 minutes ago" or
+// "yesterday", and absolute times in one of absoluteTimeLayouts
+func parseCheckpointTime(s string, now time.Time) (time.Time, bool) {
+	lower := strings.ToLower(s)
+
+	switch lower {
+	case "now":
+		return now, true
+	case "yesterday":
+		return now.AddDate(0, 0, -1), true
+	}
+
+	if m := relativeRegexp.FindStringSubmatch(lower); m != nil {
+		n := 1
+		if m[1] != "a" && m[1] != "an" {
+			n, _ = strconv.Atoi(m[1])
+		}
+
+		units := map[string]time.Duration{
+			"second": time.Second,
+			"minute": time.Minute,
+			"hour":   time.Hour,
+			"day":    24 * time.Hour,
+			"week":   7 * 24 * time.Hour,
+		}
+
+		return now.Add(-time.Duration(n) * units[m[2]]), true
+	}
+
+	for _, layout := range absoluteTimeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
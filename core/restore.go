@@ -0,0 +1,541 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+var (
+	ErrNoPathsMatched = errors.New("none of the given paths are in the checkpoint")
+	ErrWorktreeDirty  = errors.New("the worktree has uncommitted changes, not restoring")
+	ErrMergeConflicts = errors.New("the checkpoint was merged with conflicts")
+	ErrWorktreeMoved  = errors.New("the worktree changed while confirming, nothing was restored")
+)
+
+// RestoreMode decides what happens to uncommitted changes in the worktree
+// when a checkpoint is restored. In every mode, the worktree is saved as a
+// pre-restore checkpoint before anything is written.
+type RestoreMode int
+
+const (
+	// RestoreForce overwrites the files in the worktree with the checkpoint's
+	RestoreForce RestoreMode = iota
+	// RestoreAbortIfDirty refuses to restore when the worktree has changes
+	// that aren't committed
+	RestoreAbortIfDirty
+	// RestoreMerge merges the checkpoint into the worktree, writing conflict
+	// markers where both changed the same lines
+	RestoreMerge
+)
+
+// RestoreOptions configure RestoreByCommitHash
+type RestoreOptions struct {
+	Mode RestoreMode
+	// Paths limits the restore to the matching files, which are written back
+	// even if they were deleted since. Other files are never deleted then.
+	Paths []string
+}
+
+// restoreAction is what restoring does to one file
+type restoreAction string
+
+const (
+	restoreCreate   restoreAction = "create"
+	restoreModify   restoreAction = "modify"
+	restoreDelete   restoreAction = "delete"
+	restoreMerge    restoreAction = "merge"
+	restoreConflict restoreAction = "conflict"
+)
+
+// restoreChange is a planned change to one file of the worktree. Either file
+// is written, or contents with mode, unless the action is a delete.
+type restoreChange struct {
+	name     string
+	action   restoreAction
+	file     *object.File
+	contents *string
+	mode     filemode.FileMode
+}
+
+// RestoreByCommitHash prints the files that restoring the checkpoint would
+// touch, asks for confirmation, saves a pre-restore checkpoint of the worktree
+// and then restores it. HEAD and the index are never changed.
+func (asd *AsdRepository) RestoreByCommitHash(hashString string, opts RestoreOptions) error {
+	if !plumbing.IsHash(hashString) {
+		return ErrInvalidHash
+	}
+
+	hash := plumbing.NewHash(hashString)
+
+	c, ref, err := findCheckpoint(asd.Repository, hash)
+	if err != nil {
+		return err
+	}
+
+	branch, userCommit, _ := parseAutosavedRefName(ref.Name())
+	fmt.Printf("Checkpoint %s was saved on branch %s, on top of commit %s\n", hashString[:6], branch, userCommit.String()[:6])
+
+	if len(opts.Paths) > 0 {
+		if _, err = checkpointFiles(c, opts.Paths); err != nil {
+			return err
+		}
+	}
+
+	// this snapshot is only a preview, the plan is made again from the
+	// pre-restore checkpoint once the user confirms
+	worktree, err := asd.targetTree(DiffWorktree)
+	if err != nil {
+		return err
+	}
+
+	if err = asd.checkRestoreMode(worktree, opts); err != nil {
+		return err
+	}
+
+	var base *object.Tree
+	if opts.Mode == RestoreMerge {
+		base, err = asd.mergeBaseTree(userCommit)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes, err := asd.planRestore(c, worktree, base, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("\nThe worktree already matches the checkpoint, there is nothing to restore\n")
+		return nil
+	}
+
+	fmt.Printf("\nThese files will be touched:\n")
+	for _, ch := range changes {
+		note := ""
+		if ch.action == restoreConflict && ch.contents == nil {
+			note = " (changed differently in both, keeping the worktree's version)"
+		}
+
+		fmt.Printf("\t%-8s  %s%s\n", ch.action, ch.name, note)
+	}
+
+	color.New(color.FgCyan).Printf("\nTip: you can run `autosaved diff %s` to confirm your changes\n", hash.String()[:7])
+
+	questionString := color.New(color.FgYellow).Sprintf(`Are you sure you want to restore to checkpoint %s?`, hashString[:6])
+
+	if !askForConfirmation(questionString) {
+		return ErrUserDidNotConfirm
+	}
+
+	if changes, err = asd.restoreCheckpoint(c, base, opts, changes); err != nil {
+		return err
+	}
+
+	conflicts := 0
+	for _, ch := range changes {
+		if ch.action == restoreConflict {
+			conflicts++
+		}
+	}
+
+	if conflicts > 0 {
+		return fmt.Errorf("%w in %d files", ErrMergeConflicts, conflicts)
+	}
+
+	return nil
+}
+
+// checkRestoreMode refuses to restore over uncommitted changes with
+// RestoreAbortIfDirty
+func (asd *AsdRepository) checkRestoreMode(worktree *object.Tree, opts RestoreOptions) error {
+	if opts.Mode != RestoreAbortIfDirty {
+		return nil
+	}
+
+	head, err := asd.getLastUserCommitOnCurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	if worktree.Hash != head.TreeHash {
+		return ErrWorktreeDirty
+	}
+
+	return nil
+}
+
+// planRestore compares the worktree with the checkpoint and decides what to do
+// with every file that differs. Without a base tree, the checkpoint's version
+// wins. With one, the changes of both sides since base are merged.
+func (asd *AsdRepository) planRestore(c *object.Commit, worktree, base *object.Tree, opts RestoreOptions) ([]restoreChange, error) {
+	target, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := asd.Repository.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool, len(idx.Entries))
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+	}
+
+	diff, err := object.DiffTree(worktree, target)
+	if err != nil {
+		return nil, err
+	}
+
+	theirsLabel := "checkpoint " + c.Hash.String()[:7]
+
+	var changes []restoreChange
+	for _, ch := range filterChanges(diff, opts.Paths) {
+		ours, theirs, err := ch.Files()
+		if err != nil {
+			return nil, err
+		}
+
+		name := ch.To.Name
+		if name == "" {
+			name = ch.From.Name
+		}
+
+		if base != nil {
+			baseFile, err := base.File(name)
+			if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+				return nil, err
+			}
+
+			switch fileHash(baseFile) {
+			case fileHash(theirs):
+				// only the worktree changed it
+				continue
+			case fileHash(ours):
+				// only the checkpoint changed it, so it can be taken as it is
+			default:
+				change, err := mergeFiles(name, baseFile, ours, theirs, theirsLabel)
+				if err != nil {
+					return nil, err
+				}
+
+				changes = append(changes, change)
+				continue
+			}
+		}
+
+		action, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			changes = append(changes, restoreChange{name: name, action: restoreCreate, file: theirs})
+		case merkletrie.Modify:
+			changes = append(changes, restoreChange{name: name, action: restoreModify, file: theirs})
+		case merkletrie.Delete:
+			// untracked files are left alone, as is everything outside of
+			// the given paths
+			if len(opts.Paths) == 0 && tracked[name] {
+				changes = append(changes, restoreChange{name: name, action: restoreDelete})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// mergeFiles does a three-way merge of a file changed in both the worktree and
+// the checkpoint. Deleted and binary files can't be merged, the worktree's
+// version is kept for them.
+func mergeFiles(name string, base, ours, theirs *object.File, theirsLabel string) (restoreChange, error) {
+	conflict := restoreChange{name: name, action: restoreConflict}
+	if ours == nil || theirs == nil {
+		return conflict, nil
+	}
+
+	baseContent, baseBinary, err := textContent(base)
+	if err != nil {
+		return conflict, err
+	}
+
+	oursContent, oursBinary, err := textContent(ours)
+	if err != nil {
+		return conflict, err
+	}
+
+	theirsContent, theirsBinary, err := textContent(theirs)
+	if err != nil {
+		return conflict, err
+	}
+
+	if baseBinary || oursBinary || theirsBinary || ours.Mode == filemode.Symlink {
+		return conflict, nil
+	}
+
+	merged, hasConflicts := merge3(baseContent, oursContent, theirsContent, "worktree", theirsLabel)
+
+	change := restoreChange{name: name, action: restoreMerge, contents: &merged, mode: ours.Mode}
+	if hasConflicts {
+		change.action = restoreConflict
+	}
+
+	return change, nil
+}
+
+// mergeBaseTree returns the tree of the common ancestor of HEAD and the
+// commit a checkpoint was saved on. The worktree and the checkpoint both
+// started from there.
+func (asd *AsdRepository) mergeBaseTree(userCommitHash plumbing.Hash) (*object.Tree, error) {
+	r := asd.Repository
+
+	head, err := asd.getLastUserCommitOnCurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	if head.Hash == userCommitHash {
+		return head.Tree()
+	}
+
+	userCommit, err := r.CommitObject(userCommitHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := head.MergeBase(userCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bases) == 0 {
+		// unrelated histories, everything was added on both sides
+		return &object.Tree{}, nil
+	}
+
+	return bases[0].Tree()
+}
+
+// restoreCheckpoint saves the worktree as a pre-restore checkpoint, plans
+// the restore again from the tree that was saved, records it in the restore
+// journal so that the restore can be undone, and then applies the changes.
+// Files may have changed since the user was shown the plan, so nothing is
+// restored when the new plan differs from it. Everything else, including HEAD
+// and the index, is left alone.
+func (asd *AsdRepository) restoreCheckpoint(c *object.Commit, base *object.Tree, opts RestoreOptions, shown []restoreChange) ([]restoreChange, error) {
+	r := asd.Repository
+
+	backup, err := asd.save(context.Background(), fmt.Sprintf("pre-restore checkpoint, before restoring %s", c.Hash.String()[:7]))
+	switch {
+	case err == nil:
+		fmt.Printf("Saved the worktree as checkpoint %s before restoring\n", backup.String()[:7])
+	case errors.Is(err, ErrNothingToSave):
+	default:
+		return nil, fmt.Errorf("couldn't save a pre-restore checkpoint, nothing was restored: %w", err)
+	}
+
+	var worktree *object.Tree
+	if backup.IsZero() {
+		// the worktree is the same as HEAD or the last checkpoint, so its
+		// tree is already in the repository, which GetTree makes sure of
+		snapshot, err := asd.targetTree(DiffWorktree)
+		if err != nil {
+			return nil, err
+		}
+
+		if worktree, err = object.GetTree(r.Storer, snapshot.Hash); err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				return nil, ErrWorktreeMoved
+			}
+
+			return nil, err
+		}
+	} else {
+		commit, err := r.CommitObject(backup)
+		if err != nil {
+			return nil, err
+		}
+
+		if worktree, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = asd.checkRestoreMode(worktree, opts); err != nil {
+		return nil, err
+	}
+
+	changes, err := asd.planRestore(c, worktree, base, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !samePlan(shown, changes) {
+		return nil, ErrWorktreeMoved
+	}
+
+	entry := restoreJournalEntry{
+		Checkpoint: c.Hash.String(),
+		Tree:       worktree.Hash.String(),
+		Time:       time.Now(),
+	}
+
+	if !backup.IsZero() {
+		entry.Backup = backup.String()
+	}
+
+	for _, ch := range changes {
+		if ch.action != restoreConflict || ch.contents != nil {
+			entry.Paths = append(entry.Paths, ch.name)
+		}
+	}
+
+	if err = asd.pushRestoreJournal(entry); err != nil {
+		return nil, fmt.Errorf("couldn't write the restore journal, nothing was restored: %w", err)
+	}
+
+	return changes, asd.applyRestore(changes)
+}
+
+// samePlan tells if two plans touch the same files in the same way
+func samePlan(a, b []restoreChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].name != b[i].name || a[i].action != b[i].action {
+			return false
+		}
+	}
+
+	return true
+}
+
+// applyRestore writes the planned changes to the worktree
+func (asd *AsdRepository) applyRestore(changes []restoreChange) error {
+	w, err := asd.Repository.Worktree()
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range changes {
+		switch {
+		case ch.action == restoreDelete:
+			err = w.Filesystem.Remove(ch.name)
+			if err != nil && os.IsNotExist(err) {
+				err = nil
+			}
+		case ch.file != nil:
+			err = writeFile(w.Filesystem, ch.file)
+		case ch.contents != nil:
+			err = writeBlob(w.Filesystem, ch.name, ch.mode, strings.NewReader(*ch.contents))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkpointFiles returns the files of the checkpoint matching paths
+func checkpointFiles(c *object.Commit, paths []string) ([]*object.File, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*object.File
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if matchesPaths(f.Name, paths) {
+			files = append(files, f)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoPathsMatched
+	}
+
+	return files, nil
+}
+
+func fileHash(f *object.File) plumbing.Hash {
+	if f == nil {
+		return plumbing.ZeroHash
+	}
+
+	return f.Hash
+}
+
+// writeFile writes the contents of f to fs with its mode, replacing whatever
+// was at its path before
+func writeFile(fs billy.Filesystem, f *object.File) error {
+	src, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeBlob(fs, f.Name, f.Mode, src)
+}
+
+// writeBlob writes contents to name in fs, as a symlink or a file with the
+// permissions of mode
+func writeBlob(fs billy.Filesystem, name string, mode filemode.FileMode, contents io.Reader) error {
+	if err := fs.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	if err := fs.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if mode == filemode.Symlink {
+		target, err := io.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+
+		return fs.Symlink(string(target), name)
+	}
+
+	perm, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	dst, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, contents); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
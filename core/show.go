@@ -0,0 +1,241 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	ErrPathNotInCheckpoint = errors.New("path not found in the checkpoint")
+	ErrPathIsDirectory     = errors.New("path is a directory, use ls to list it")
+	ErrNoPathInSpec        = errors.New("expected a checkpoint and a path separated by a colon")
+)
+
+// CheckpointInfo describes a checkpoint for `autosaved show`
+type CheckpointInfo struct {
+	Commit
+	// Branch is the branch it was saved on, and UserCommit the commit its
+	// chain was saved on top of
+	Branch     string `json:"branch"`
+	UserCommit Commit `json:"user_commit"`
+	Ref        string `json:"ref"`
+	// Parent is the checkpoint before it, or the user commit for the first
+	// one of a chain
+	Parent string `json:"parent"`
+	Stats  *Stats `json:"stats"`
+}
+
+// DescribeCheckpoint returns where the checkpoint with the given hash was
+// saved, and what it changed
+func (asd *AsdRepository) DescribeCheckpoint(hash plumbing.Hash) (*CheckpointInfo, error) {
+	r := asd.Repository
+
+	c, ref, err := findCheckpoint(r, hash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, hash)
+	}
+
+	branch, userHash, _ := parseAutosavedRefName(ref.Name())
+	userCommit, err := r.CommitObject(userHash)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := asd.CheckpointStats(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckpointInfo{
+		Commit:     NewCommit(c),
+		Branch:     branch,
+		UserCommit: NewCommit(userCommit),
+		Ref:        ref.Name().String(),
+		Parent:     c.ParentHashes[0].String(),
+		Stats:      stats,
+	}, nil
+}
+
+// CheckpointPatch returns the changes made by a checkpoint from its parent,
+// limited to the given paths if there are any
+func (asd *AsdRepository) CheckpointPatch(hash plumbing.Hash, paths []string) (*object.Patch, error) {
+	c, err := asd.Repository.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.NumParents() == 0 {
+		to, err := c.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		return diffTrees(nil, to, paths)
+	}
+
+	return asd.DiffCheckpoints(c.ParentHashes[0], hash, paths)
+}
+
+// CheckpointFile returns the file at the slash separated path in a
+// checkpoint
+func (asd *AsdRepository) CheckpointFile(hash plumbing.Hash, name string) (*object.File, error) {
+	tree, err := asd.commitTree(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	name = normalizePath(name)
+
+	entry, err := tree.FindEntry(name)
+	if err != nil {
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotInCheckpoint, name)
+		}
+
+		return nil, err
+	}
+
+	if entry.Mode == filemode.Dir {
+		return nil, fmt.Errorf("%w: %s", ErrPathIsDirectory, name)
+	}
+
+	return tree.TreeEntryFile(entry)
+}
+
+// TreeEntry is a file or directory of a checkpoint, as listed by ls
+type TreeEntry struct {
+	Mode string `json:"mode"`
+	// Type is blob, tree or commit, for files, directories and submodules
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+	// Size is the size of files, and 0 for anything else
+	Size int64 `json:"size"`
+}
+
+// CheckpointTree lists what is in the directory dir of a checkpoint, or in
+// its root when dir is empty. With recursive, the files inside of
+// subdirectories are listed instead of the subdirectories.
+func (asd *AsdRepository) CheckpointTree(hash plumbing.Hash, dir string, recursive bool) ([]TreeEntry, error) {
+	tree, err := asd.commitTree(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if dir = normalizePath(dir); dir != "" && dir != "." {
+		entry, err := tree.FindEntry(dir)
+		if err != nil {
+			if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotInCheckpoint, dir)
+			}
+
+			return nil, err
+		}
+
+		if entry.Mode != filemode.Dir {
+			// like ls, a file lists itself
+			te, err := newTreeEntry(tree, dir, *entry)
+			if err != nil {
+				return nil, err
+			}
+
+			return []TreeEntry{te}, nil
+		}
+
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil, err
+		}
+		prefix = dir + "/"
+	}
+
+	var entries []TreeEntry
+	if recursive {
+		walker := object.NewTreeWalker(tree, true, nil)
+		defer walker.Close()
+
+		for {
+			name, entry, err := walker.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return nil, err
+			}
+
+			if entry.Mode == filemode.Dir {
+				continue
+			}
+
+			te, err := newTreeEntry(tree, prefix+name, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, te)
+		}
+	} else {
+		for _, entry := range tree.Entries {
+			te, err := newTreeEntry(tree, prefix+entry.Name, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, te)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+func newTreeEntry(tree *object.Tree, name string, entry object.TreeEntry) (TreeEntry, error) {
+	te := TreeEntry{
+		Mode: fmt.Sprintf("%06o", uint32(entry.Mode)),
+		Hash: entry.Hash.String(),
+		Path: name,
+	}
+
+	switch entry.Mode {
+	case filemode.Dir:
+		te.Type = "tree"
+	case filemode.Submodule:
+		te.Type = "commit"
+	default:
+		te.Type = "blob"
+
+		f, err := tree.TreeEntryFile(&entry)
+		if err != nil {
+			return te, err
+		}
+		te.Size = f.Size
+	}
+
+	return te, nil
+}
+
+// SplitCheckpointPath splits "<checkpoint>:<path>" at the last colon before
+// which the checkpoint resolves, as times like "14:00:05" have colons too
+func (asd *AsdRepository) SplitCheckpointPath(s string) (*object.Commit, string, error) {
+	err := fmt.Errorf("%w: %q", ErrNoPathInSpec, s)
+	for i := strings.LastIndex(s, ":"); i >= 0; i = strings.LastIndex(s[:i], ":") {
+		c, resolveErr := asd.ResolveCheckpoint(s[:i])
+		if resolveErr == nil {
+			return c, s[i+1:], nil
+		}
+		err = resolveErr
+	}
+
+	return nil, "", err
+}
@@ -0,0 +1,342 @@
+package core
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// snapshotEntry is a single file of a snapshot, as it will be written into
+// the tree
+type snapshotEntry struct {
+	Mode filemode.FileMode
+	Hash plumbing.Hash
+}
+
+// snapshotWorktree writes the current state of the worktree into s and
+// returns the hash of the resulting root tree. Files which are unchanged are
+// taken from the index, changed and untracked (but not ignored) files are
+// read from the filesystem. HEAD, the index and the worktree are only read.
+func (asd *AsdRepository) snapshotWorktree(ctx context.Context, w *git.Worktree, s storer.EncodedObjectStorer) (plumbing.Hash, error) {
+	r := asd.Repository
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries := make(map[string]snapshotEntry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		entries[e.Name] = snapshotEntry{Mode: e.Mode, Hash: e.Hash}
+	}
+
+	changes, err := diffStagingWithWorktree(ctx, w, r, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var submodules map[string]plumbing.Hash
+	for _, ch := range changes {
+		if err = ctx.Err(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		a, err := ch.Action()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		name := nameFromAction(&ch)
+		if a == merkletrie.Delete {
+			delete(entries, name)
+			continue
+		}
+
+		fi, err := w.Filesystem.Lstat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed while we were walking the worktree
+				delete(entries, name)
+				continue
+			}
+
+			return plumbing.ZeroHash, err
+		}
+
+		if fi.IsDir() {
+			// only submodules show up as directories in the changes
+			if submodules == nil {
+				submodules, err = getSubmodulesStatus(w)
+				if err != nil {
+					return plumbing.ZeroHash, err
+				}
+			}
+
+			if h, ok := submodules[name]; ok {
+				entries[name] = snapshotEntry{Mode: filemode.Submodule, Hash: h}
+			}
+
+			continue
+		}
+
+		entry, err := writeWorktreeBlob(w, s, name, fi)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		entries[name] = entry
+	}
+
+	return writeTree(s, entries)
+}
+
+// writeWorktreeBlob stores the file at name as a blob in s. The file is
+// hashed first, so that one which is stored already isn't read into memory.
+func writeWorktreeBlob(w *git.Worktree, s storer.EncodedObjectStorer, name string, fi os.FileInfo) (snapshotEntry, error) {
+	mode, err := filemode.NewFromOSFileMode(fi.Mode())
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+
+	h, ok, err := hashWorktreeFile(w, name, fi)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+
+	if ok && s.HasEncodedObject(h) == nil {
+		return snapshotEntry{Mode: mode, Hash: h}, nil
+	}
+
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(fi.Size())
+
+	dst, err := obj.Writer()
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := w.Filesystem.Readlink(name)
+		if err != nil {
+			dst.Close()
+			return snapshotEntry{}, err
+		}
+
+		_, err = dst.Write([]byte(target))
+		if err != nil {
+			dst.Close()
+			return snapshotEntry{}, err
+		}
+	} else {
+		src, err := w.Filesystem.Open(name)
+		if err != nil {
+			dst.Close()
+			return snapshotEntry{}, err
+		}
+
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			dst.Close()
+			return snapshotEntry{}, err
+		}
+	}
+
+	if err = dst.Close(); err != nil {
+		return snapshotEntry{}, err
+	}
+
+	h, err = s.SetEncodedObject(obj)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+
+	return snapshotEntry{Mode: mode, Hash: h}, nil
+}
+
+// hashWorktreeFile streams the file at name through the blob hasher. ok is
+// false when the file changed size since fi was read, as the hash is wrong
+// then.
+func hashWorktreeFile(w *git.Worktree, name string, fi os.FileInfo) (plumbing.Hash, bool, error) {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := w.Filesystem.Readlink(name)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+
+		return plumbing.ComputeHash(plumbing.BlobObject, []byte(target)), true, nil
+	}
+
+	src, err := w.Filesystem.Open(name)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	defer src.Close()
+
+	hasher := plumbing.NewHasher(plumbing.BlobObject, fi.Size())
+	n, err := io.Copy(hasher, src)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	return hasher.Sum(), n == fi.Size(), nil
+}
+
+// writeTree builds the tree objects for the given files (keyed by their slash
+// separated path), stores them in s and returns the hash of the root tree
+func writeTree(s storer.EncodedObjectStorer, entries map[string]snapshotEntry) (plumbing.Hash, error) {
+	const rootNode = ""
+	trees := map[string]*object.Tree{rootNode: {}}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var fullpath string
+		for _, part := range strings.Split(name, "/") {
+			parent := fullpath
+			fullpath = path.Join(fullpath, part)
+
+			if _, ok := trees[fullpath]; ok {
+				continue
+			}
+
+			te := object.TreeEntry{Name: part}
+			if fullpath == name {
+				te.Mode = entries[name].Mode
+				te.Hash = entries[name].Hash
+			} else {
+				te.Mode = filemode.Dir
+				trees[fullpath] = &object.Tree{}
+			}
+
+			trees[parent].Entries = append(trees[parent].Entries, te)
+		}
+	}
+
+	return writeTreeRecursive(s, trees, rootNode)
+}
+
+func writeTreeRecursive(s storer.EncodedObjectStorer, trees map[string]*object.Tree, name string) (plumbing.Hash, error) {
+	t := trees[name]
+	sort.Slice(t.Entries, func(i, j int) bool {
+		return treeEntrySortName(t.Entries[i]) < treeEntrySortName(t.Entries[j])
+	})
+
+	for i, e := range t.Entries {
+		if e.Mode != filemode.Dir {
+			continue
+		}
+
+		h, err := writeTreeRecursive(s, trees, path.Join(name, e.Name))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		t.Entries[i].Hash = h
+	}
+
+	obj := s.NewEncodedObject()
+	if err := t.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if s.HasEncodedObject(obj.Hash()) == nil {
+		return obj.Hash(), nil
+	}
+
+	return s.SetEncodedObject(obj)
+}
+
+// git sorts tree entries as if directories had a trailing slash
+func treeEntrySortName(te object.TreeEntry) string {
+	if te.Mode == filemode.Dir {
+		return te.Name + "/"
+	}
+
+	return te.Name
+}
+
+// overlayStorer writes new trees to memory and reads everything else from
+// the repository. It allows hashing the worktree without leaving trees
+// behind when it turns out that nothing has to be saved. Blobs go straight to
+// the repository instead, as files can be too big to keep in memory. Like
+// the ones left by `git add`, those that end up unused are removed by
+// `git gc`.
+type overlayStorer struct {
+	storer.EncodedObjectStorer
+
+	mem *memory.ObjectStorage
+}
+
+func newOverlayStorer(s storer.EncodedObjectStorer) *overlayStorer {
+	return &overlayStorer{EncodedObjectStorer: s, mem: &memory.NewStorage().ObjectStorage}
+}
+
+func (o *overlayStorer) NewEncodedObject() plumbing.EncodedObject {
+	return o.mem.NewEncodedObject()
+}
+
+func (o *overlayStorer) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if obj.Type() == plumbing.BlobObject {
+		return o.EncodedObjectStorer.SetEncodedObject(obj)
+	}
+
+	return o.mem.SetEncodedObject(obj)
+}
+
+func (o *overlayStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := o.mem.EncodedObject(t, h)
+	if err == nil {
+		return obj, nil
+	}
+
+	return o.EncodedObjectStorer.EncodedObject(t, h)
+}
+
+func (o *overlayStorer) HasEncodedObject(h plumbing.Hash) error {
+	if o.mem.HasEncodedObject(h) == nil {
+		return nil
+	}
+
+	return o.EncodedObjectStorer.HasEncodedObject(h)
+}
+
+func (o *overlayStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := o.mem.EncodedObjectSize(h)
+	if err == nil {
+		return size, nil
+	}
+
+	return o.EncodedObjectStorer.EncodedObjectSize(h)
+}
+
+// flush writes the objects kept in memory to the underlying storer
+func (o *overlayStorer) flush() error {
+	for h, obj := range o.mem.Objects {
+		if o.EncodedObjectStorer.HasEncodedObject(h) == nil {
+			continue
+		}
+
+		if _, err := o.EncodedObjectStorer.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
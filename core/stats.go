@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// statsDir caches the stats of checkpoints inside of asdDir, one file per
+// checkpoint named after its hash. Commits never change, so the files are
+// never out of date.
+const statsDir = "stats"
+
+// statsVersion is bumped when the way stats are computed changes, so that
+// the cached ones are computed again
+const statsVersion = 1
+
+// FileStat is how a checkpoint changed one file
+type FileStat struct {
+	Path string `json:"path"`
+	// Status is A, M or D, for added, modified or deleted
+	Status  string `json:"status"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// Stats are the changes of a checkpoint from its parent, the checkpoint
+// before it or the user commit it was saved on
+type Stats struct {
+	Version int        `json:"version"`
+	Files   []FileStat `json:"files"`
+	Added   int        `json:"added"`
+	Removed int        `json:"removed"`
+}
+
+// CheckpointStats returns the changes made by c, from the cache when they
+// were computed before
+func (asd *AsdRepository) CheckpointStats(c *object.Commit) (*Stats, error) {
+	if s, ok := asd.cachedStats(c); ok {
+		return s, nil
+	}
+
+	s, err := computeStats(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = asd.cacheStats(c, s); err != nil {
+		// it is computed again next time
+		asd.log("stats").Debugf("couldn't cache the stats of %s: %v", c.Hash, err)
+	}
+
+	return s, nil
+}
+
+func computeStats(c *object.Commit) (*Stats, error) {
+	to, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if from, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stats{Version: statsVersion, Files: []FileStat{}}
+	for _, ch := range changes {
+		action, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		fs := FileStat{Path: ch.To.Name}
+		switch action {
+		case merkletrie.Insert:
+			fs.Status = "A"
+		case merkletrie.Delete:
+			fs.Status = "D"
+			fs.Path = ch.From.Name
+		default:
+			fs.Status = "M"
+		}
+
+		patch, err := ch.Patch()
+		if err != nil {
+			return nil, err
+		}
+
+		// binary files have no lines, and count as 0
+		for _, st := range patch.Stats() {
+			fs.Added += st.Addition
+			fs.Removed += st.Deletion
+		}
+
+		s.Files = append(s.Files, fs)
+		s.Added += fs.Added
+		s.Removed += fs.Removed
+	}
+
+	sort.Slice(s.Files, func(i, j int) bool {
+		return s.Files[i].Path < s.Files[j].Path
+	})
+
+	return s, nil
+}
+
+func statsPath(c *object.Commit) string {
+	return path.Join(asdDir, statsDir, c.Hash.String()+".json")
+}
+
+func (asd *AsdRepository) cachedStats(c *object.Commit) (*Stats, bool) {
+	fs, err := asd.gitDirFilesystem()
+	if err != nil {
+		return nil, false
+	}
+
+	f, err := fs.Open(statsPath(c))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var s Stats
+	if err = json.NewDecoder(f).Decode(&s); err != nil || s.Version != statsVersion {
+		return nil, false
+	}
+
+	return &s, true
+}
+
+// cacheStats writes the stats through a rename, so that a half written file
+// is never read
+func (asd *AsdRepository) cacheStats(c *object.Commit, s *Stats) error {
+	fs, err := asd.gitDirFilesystem()
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(asdDir, statsDir)
+	if err = fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := fs.TempFile(dir, c.Hash.String())
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		fs.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		fs.Remove(f.Name())
+		return err
+	}
+
+	if err = fs.Rename(f.Name(), statsPath(c)); err != nil {
+		fs.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// removeCachedStats drops the cached stats of checkpoints that are gone
+func (asd *AsdRepository) removeCachedStats(commits []*object.Commit) {
+	fs, err := asd.gitDirFilesystem()
+	if err != nil {
+		return
+	}
+
+	for _, c := range commits {
+		if err := fs.Remove(statsPath(c)); err != nil && !os.IsNotExist(err) {
+			asd.log("stats").Debugf("couldn't remove the cached stats of %s: %v", c.Hash, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ChangeThresholds are amounts of change which make a repository worth
+// saving. Zero values are not checked.
+type ChangeThresholds struct {
+	Words int
+	Lines int
+	Files int
+}
+
+// IsZero reports whether none of the thresholds are set
+func (t ChangeThresholds) IsZero() bool {
+	return t.Words <= 0 && t.Lines <= 0 && t.Files <= 0
+}
+
+// reached returns a description of the first threshold that the stats reach,
+// or an empty string when none of them are reached
+func (t ChangeThresholds) reached(stats ChangeStats, key string) string {
+	switch {
+	case t.Lines > 0 && stats.Lines() >= t.Lines:
+		return fmt.Sprintf("%d changed lines reached %s.lines (%d)", stats.Lines(), key, t.Lines)
+	case t.Words > 0 && stats.Words() >= t.Words:
+		return fmt.Sprintf("%d changed words reached %s.words (%d)", stats.Words(), key, t.Words)
+	case t.Files > 0 && stats.Files >= t.Files:
+		return fmt.Sprintf("%d changed files reached %s.files (%d)", stats.Files, key, t.Files)
+	}
+
+	return ""
+}
+
+// ChangeStats measures the difference between two trees
+type ChangeStats struct {
+	Files        int
+	LinesAdded   int
+	LinesRemoved int
+	WordsAdded   int
+	WordsRemoved int
+}
+
+// Lines is the number of lines added or removed
+func (s ChangeStats) Lines() int {
+	return s.LinesAdded + s.LinesRemoved
+}
+
+// Words is the number of words added or removed
+func (s ChangeStats) Words() int {
+	return s.WordsAdded + s.WordsRemoved
+}
+
+// SetChangeThresholds is the Setter method for the change volume
+// configuration. after is combined with the minimum duration, so both have
+// to be reached, while regardless triggers a save no matter how recent the
+// last one was.
+func (asd *AsdRepository) SetChangeThresholds(after, regardless ChangeThresholds) error {
+	asd.afterThresholds = after
+	asd.regardlessThresholds = regardless
+	return nil
+}
+
+// changeStatsSinceLastSave compares the worktree with the last checkpoint, or
+// the user commit if nothing was saved on top of it yet
+func (asd *AsdRepository) changeStatsSinceLastSave(ctx context.Context, userCommit, autosavedCommit *object.Commit) (ChangeStats, error) {
+	base := userCommit
+	if autosavedCommit != nil {
+		base = autosavedCommit
+	}
+
+	baseTree, err := base.Tree()
+	if err != nil {
+		return ChangeStats{}, err
+	}
+
+	w, err := asd.Repository.Worktree()
+	if err != nil {
+		return ChangeStats{}, err
+	}
+
+	s := newOverlayStorer(asd.Repository.Storer)
+	h, err := asd.snapshotWorktree(ctx, w, s)
+	if err != nil {
+		return ChangeStats{}, err
+	}
+
+	worktreeTree, err := object.GetTree(s, h)
+	if err != nil {
+		return ChangeStats{}, err
+	}
+
+	return diffTreeStats(ctx, baseTree, worktreeTree)
+}
+
+// diffTreeStats counts the files, lines and words that differ between two
+// trees. Binary files only count as a changed file.
+func diffTreeStats(ctx context.Context, from, to *object.Tree) (ChangeStats, error) {
+	var stats ChangeStats
+
+	changes, err := object.DiffTreeWithOptions(ctx, from, to, nil)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, ch := range changes {
+		if err = ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		stats.Files++
+
+		fromFile, toFile, err := ch.Files()
+		if err != nil {
+			return stats, err
+		}
+
+		fromContent, fromBinary, err := textContent(fromFile)
+		if err != nil {
+			return stats, err
+		}
+
+		toContent, toBinary, err := textContent(toFile)
+		if err != nil {
+			return stats, err
+		}
+
+		if fromBinary || toBinary {
+			continue
+		}
+
+		added, removed := countDiff(diff.Do(fromContent, toContent))
+		stats.LinesAdded += added
+		stats.LinesRemoved += removed
+
+		// diffing one word per line gives a word level diff
+		fromWords := strings.Join(strings.Fields(fromContent), "\n")
+		toWords := strings.Join(strings.Fields(toContent), "\n")
+		added, removed = countDiff(diff.Do(fromWords, toWords))
+		stats.WordsAdded += added
+		stats.WordsRemoved += removed
+	}
+
+	return stats, nil
+}
+
+func textContent(f *object.File) (string, bool, error) {
+	if f == nil {
+		return "", false, nil
+	}
+
+	isBinary, err := f.IsBinary()
+	if err != nil || isBinary {
+		return "", isBinary, err
+	}
+
+	content, err := f.Contents()
+	return content, false, err
+}
+
+// countDiff counts the lines inserted and deleted in a line oriented diff.
+// A line is counted once whether or not it ends with a newline, and empty
+// chunks count for nothing.
+func countDiff(diffs []diffmatchpatch.Diff) (added, removed int) {
+	for _, d := range diffs {
+		if d.Text == "" {
+			continue
+		}
+
+		// the last line may have no newline
+		n := strings.Count(strings.TrimSuffix(d.Text, "\n"), "\n") + 1
+
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			added += n
+		case diffmatchpatch.DiffDelete:
+			removed += n
+		}
+	}
+
+	return added, removed
+}
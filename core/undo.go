@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+var (
+	ErrNothingToUndo      = errors.New("there is no restore to undo")
+	ErrNoGitDir           = errors.New("the repository isn't stored on disk, autosaved can't keep its files in it")
+	ErrRestoreJournalGone = errors.New("the worktree saved before this restore is no longer in the repository")
+)
+
+const (
+	// asdDir holds autosaved's own files, inside of the .git directory
+	asdDir             = "autosaved"
+	restoreJournalFile = "restore-journal.json"
+)
+
+// restoreJournalEntry records one restore, with what is needed to undo it
+type restoreJournalEntry struct {
+	// Checkpoint is the checkpoint that was restored
+	Checkpoint string `json:"checkpoint"`
+	// Backup is the pre-restore checkpoint, empty if the worktree didn't
+	// need saving
+	Backup string `json:"backup,omitempty"`
+	// Tree is the tree of the worktree right before the restore
+	Tree string `json:"tree"`
+	// Paths are the files written or deleted by the restore
+	Paths []string  `json:"paths"`
+	Time  time.Time `json:"time"`
+}
+
+// UndoRestore puts back the files touched by the latest restore that hasn't
+// been undone yet, as they were right before it. Calling it again undoes the
+// restore before that one. Like a restore, it first saves the worktree as a
+// checkpoint, so the undo can itself be restored.
+func (asd *AsdRepository) UndoRestore() error {
+	journal, err := asd.readRestoreJournal()
+	if err != nil {
+		return err
+	}
+
+	if len(journal) == 0 {
+		return ErrNothingToUndo
+	}
+
+	entry := journal[len(journal)-1]
+
+	tree, err := asd.journalledTree(entry)
+	if err != nil {
+		return err
+	}
+
+	changes, err := asd.planUndo(tree, entry.Paths)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The latest restore was of checkpoint %s, %s\n", entry.Checkpoint[:6], entry.Time.Local().Format("2006-01-02 15:04:05"))
+
+	if len(changes) > 0 {
+		fmt.Printf("\nThese files will be touched:\n")
+		for _, ch := range changes {
+			fmt.Printf("\t%-8s  %s\n", ch.action, ch.name)
+		}
+	}
+
+	questionString := color.New(color.FgYellow).Sprintf(`Are you sure you want to undo the restore of checkpoint %s?`, entry.Checkpoint[:6])
+	if !askForConfirmation(questionString) {
+		return ErrUserDidNotConfirm
+	}
+
+	if len(changes) > 0 {
+		backup, err := asd.save(context.Background(), fmt.Sprintf("pre-undo checkpoint, before undoing the restore of %s", entry.Checkpoint[:7]))
+		switch {
+		case err == nil:
+			fmt.Printf("Saved the worktree as checkpoint %s before undoing\n", backup.String()[:7])
+		case !errors.Is(err, ErrNothingToSave):
+			return fmt.Errorf("couldn't save a pre-undo checkpoint, nothing was undone: %w", err)
+		}
+
+		if err = asd.applyRestore(changes); err != nil {
+			return err
+		}
+	}
+
+	return asd.writeRestoreJournal(journal[:len(journal)-1])
+}
+
+// planUndo returns the changes that bring the given paths back to how they
+// are in tree. Paths missing from tree were created by the restore and get
+// deleted.
+func (asd *AsdRepository) planUndo(tree *object.Tree, paths []string) ([]restoreChange, error) {
+	worktree, err := asd.targetTree(DiffWorktree)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []restoreChange
+	for _, name := range paths {
+		before, err := tree.File(name)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+
+		now, err := worktree.File(name)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+
+		switch {
+		case fileHash(before) == fileHash(now) && (before == nil || before.Mode == now.Mode):
+			continue
+		case before == nil:
+			changes = append(changes, restoreChange{name: name, action: restoreDelete})
+		case now == nil:
+			changes = append(changes, restoreChange{name: name, action: restoreCreate, file: before})
+		default:
+			changes = append(changes, restoreChange{name: name, action: restoreModify, file: before})
+		}
+	}
+
+	return changes, nil
+}
+
+// journalledTree returns the tree of the worktree from right before a
+// restore, falling back to the tree of its pre-restore checkpoint
+func (asd *AsdRepository) journalledTree(entry restoreJournalEntry) (*object.Tree, error) {
+	r := asd.Repository
+
+	tree, err := object.GetTree(r.Storer, plumbing.NewHash(entry.Tree))
+	if err == nil || !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return tree, err
+	}
+
+	if entry.Backup == "" {
+		return nil, ErrRestoreJournalGone
+	}
+
+	backup, err := r.CommitObject(plumbing.NewHash(entry.Backup))
+	if err != nil {
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return nil, ErrRestoreJournalGone
+		}
+
+		return nil, err
+	}
+
+	return backup.Tree()
+}
+
+// pinnedCheckpoints returns the checkpoints that the restore journal refers
+// to, which Prune keeps as they are so that restores can still be undone
+func (asd *AsdRepository) pinnedCheckpoints() (map[plumbing.Hash]bool, error) {
+	journal, err := asd.readRestoreJournal()
+	if err != nil && !errors.Is(err, ErrNoGitDir) {
+		return nil, err
+	}
+
+	pinned := make(map[plumbing.Hash]bool)
+	for _, entry := range journal {
+		pinned[plumbing.NewHash(entry.Checkpoint)] = true
+		if entry.Backup != "" {
+			pinned[plumbing.NewHash(entry.Backup)] = true
+		}
+	}
+
+	return pinned, nil
+}
+
+// pushRestoreJournal adds a restore on top of the journal
+func (asd *AsdRepository) pushRestoreJournal(entry restoreJournalEntry) error {
+	journal, err := asd.readRestoreJournal()
+	if err != nil {
+		return err
+	}
+
+	return asd.writeRestoreJournal(append(journal, entry))
+}
+
+func (asd *AsdRepository) readRestoreJournal() ([]restoreJournalEntry, error) {
+	fs, err := asd.gitDirFilesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fs.Open(path.Join(asdDir, restoreJournalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	var journal []restoreJournalEntry
+	if err = json.NewDecoder(f).Decode(&journal); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("couldn't read the restore journal: %w", err)
+	}
+
+	return journal, nil
+}
+
+// writeRestoreJournal replaces the journal through a rename, so that it is
+// never left half written
+func (asd *AsdRepository) writeRestoreJournal(journal []restoreJournalEntry) error {
+	fs, err := asd.gitDirFilesystem()
+	if err != nil {
+		return err
+	}
+
+	if err = fs.MkdirAll(asdDir, 0755); err != nil {
+		return err
+	}
+
+	f, err := fs.TempFile(asdDir, restoreJournalFile)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(journal); err != nil {
+		f.Close()
+		fs.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		fs.Remove(f.Name())
+		return err
+	}
+
+	return fs.Rename(f.Name(), path.Join(asdDir, restoreJournalFile))
+}
+
+// gitDirFilesystem returns the .git directory of the repository
+func (asd *AsdRepository) gitDirFilesystem() (billy.Filesystem, error) {
+	s, ok := asd.Repository.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, ErrNoGitDir
+	}
+
+	return s.Filesystem(), nil
+}
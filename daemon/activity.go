@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"time"
+)
+
+// historySize is how many of the latest saves are remembered per repository
+const historySize = 20
+
+// repoActivity is what the daemon did with a repository, as shown by the
+// status command. It is kept in the state file across restarts.
+type repoActivity struct {
+	LastCheck      time.Time `json:"last_check"`
+	LastSave       time.Time `json:"last_save"`
+	LastSkipReason string    `json:"last_skip_reason,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	LastErrorAt    time.Time `json:"last_error_at"`
+
+	Checks int `json:"checks"`
+	Saves  int `json:"saves"`
+	Skips  int `json:"skips"`
+	Errors int `json:"errors"`
+
+	// SaveHistory holds the times of the latest saves, oldest first
+	SaveHistory []time.Time `json:"save_history,omitempty"`
+
+	// Attention says why the repository isn't being autosaved, when it was
+	// left in a state that the user has to fix
+	Attention string `json:"needs_attention,omitempty"`
+}
+
+// activityLocked returns the activity of the repository, d.mu must be held
+func (d *Daemon) activityLocked(path string) *repoActivity {
+	a, ok := d.activity[path]
+	if !ok {
+		a = &repoActivity{}
+		d.activity[path] = a
+	}
+
+	return a
+}
+
+func (d *Daemon) recordCheck(path string, err error) {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a := d.activityLocked(path)
+	a.LastCheck = now
+	a.Checks++
+	if err != nil {
+		a.LastError = err.Error()
+		a.LastErrorAt = now
+		a.Errors++
+	}
+}
+
+func (d *Daemon) recordSave(path string) {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a := d.activityLocked(path)
+	a.LastSave = now
+	a.Saves++
+
+	a.SaveHistory = append(a.SaveHistory, now)
+	if len(a.SaveHistory) > historySize {
+		a.SaveHistory = a.SaveHistory[len(a.SaveHistory)-historySize:]
+	}
+}
+
+// setAttention records why the repository needs the user's attention, or
+// that it doesn't when problem is empty, and reports whether that changed
+func (d *Daemon) setAttention(path, problem string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a := d.activityLocked(path)
+	changed := a.Attention != problem
+	a.Attention = problem
+	return changed
+}
+
+func (d *Daemon) recordSkip(path, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a := d.activityLocked(path)
+	a.LastSkipReason = reason
+	a.Skips++
+}
@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/logging"
+	viperPkg "github.com/spf13/viper"
+)
+
+// config is a snapshot of the daemon's configuration. It is never modified
+// once it's been built, a reload builds a new one and swaps it in, so it can
+// be read from any goroutine.
+type config struct {
+	checkingInterval time.Duration
+	debounce         time.Duration
+	workers          int
+	checkTimeout     time.Duration
+
+	logLevel  logging.Level
+	logOutput logOutput
+
+	metricsAddress string
+
+	minSeconds           int
+	afterThresholds      core.ChangeThresholds
+	regardlessThresholds core.ChangeThresholds
+	retention            core.RetentionPolicy
+
+	repositories map[string]*core.AsdRepository
+}
+
+// pollingInterval is the checking interval, used as a ticker period
+func (c *config) pollingInterval() time.Duration {
+	if c.checkingInterval <= 0 {
+		return time.Second
+	}
+
+	return c.checkingInterval
+}
+
+// currentConfig returns the configuration in use
+func (d *Daemon) currentConfig() *config {
+	return d.config.Load().(*config)
+}
+
+// readConfig builds a configuration from v, checking all of it before
+// anything is used. Its repositories are opened by openRepositories. v is
+// only read here, never written, so that the config file isn't changed by
+// reloading it. viperMu must be held when v is d.viper.
+func (d *Daemon) readConfig(v *viperPkg.Viper) (*config, error) {
+	cfg := &config{
+		checkingInterval: time.Duration(getIntOrDefault(v, checkingIntervalKey, defaultCheckingInterval)) * time.Second,
+		debounce:         time.Duration(getIntOrDefault(v, debounceKey, defaultDebounceSeconds)) * time.Second,
+		workers:          getIntOrDefault(v, workersKey, defaultWorkers),
+		checkTimeout:     time.Duration(getIntOrDefault(v, checkTimeoutKey, defaultCheckTimeoutSeconds)) * time.Second,
+		minSeconds:       getMinimumSeconds(v.GetInt(afterMinutesKey), v.GetInt(afterSecondsKey)),
+	}
+
+	switch {
+	case cfg.checkingInterval < 0:
+		return nil, ErrCheckingIntervalNegative
+	case cfg.debounce < 0:
+		return nil, ErrDebounceNegative
+	case cfg.workers < 1:
+		return nil, ErrWorkersNotPositive
+	case cfg.checkTimeout < 0:
+		return nil, ErrCheckTimeoutNegative
+	}
+
+	var err error
+	cfg.logLevel, err = logging.ParseLevel(getStringOrDefault(v, logLevelKey, "info"))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.logOutput, err = readLogOutput(v)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.metricsAddress, err = readMetricsAddress(v)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.afterThresholds, err = loadChangeThresholds(v, afterEveryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.regardlessThresholds, err = loadChangeThresholds(v, regardlessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.retention, err = LoadRetentionPolicy(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// openRepositories opens the repositories listed in v for cfg. Repositories
+// that can't be opened are logged and left out.
+func (d *Daemon) openRepositories(v *viperPkg.Viper, cfg *config) {
+	cfg.repositories = make(map[string]*core.AsdRepository)
+	for _, path := range v.GetStringSlice(reposKey) {
+		asdRepo, err := core.AsdRepoFromGitRepoPath(path, cfg.minSeconds)
+		if err != nil {
+			d.log.Repo(path).Op("config").Warnf("Git repo couldn't be initialised due to error: %v", err)
+			continue
+		}
+
+		asdRepo.SetChangeThresholds(cfg.afterThresholds, cfg.regardlessThresholds)
+		asdRepo.SetRetentionPolicy(cfg.retention)
+		cfg.repositories[path] = asdRepo
+	}
+}
+
+// readMetricsAddress reads the address to serve metrics on. Only loopback
+// hosts and localhost are allowed, and without a host 127.0.0.1 is used.
+func readMetricsAddress(v *viperPkg.Viper) (string, error) {
+	addr := v.GetString(metricsAddressKey)
+	if addr == "" {
+		return "", nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return "", fmt.Errorf("%w: %q", ErrBadMetricsAddress, addr)
+	}
+
+	switch ip := net.ParseIP(host); {
+	case host == "":
+		host = "127.0.0.1"
+	case host == "localhost":
+	case ip == nil || !ip.IsLoopback():
+		return "", fmt.Errorf("%w: %q", ErrMetricsNotLocal, addr)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+func getStringOrDefault(v *viperPkg.Viper, key string, def string) string {
+	if !v.IsSet(key) {
+		return def
+	}
+
+	return v.GetString(key)
+}
+
+func getIntOrDefault(v *viperPkg.Viper, key string, def int) int {
+	if !v.IsSet(key) {
+		return def
+	}
+
+	return v.GetInt(key)
+}
+
+// watchConfigFile reloads the config whenever the config file changes, until
+// the daemon stops. It is used instead of viper's WatchConfig, which reads the
+// file on its own goroutine, outside of viperMu.
+func (d *Daemon) watchConfigFile() error {
+	file := d.viper.ConfigFileUsed()
+	if file == "" {
+		return nil
+	}
+	file = filepath.Clean(file)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// the directory is watched, so that editors saving through a rename
+	// are noticed too
+	if err = w.Add(filepath.Dir(file)); err != nil {
+		w.Close()
+		return err
+	}
+
+	go func() {
+		defer w.Close()
+
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(e.Name) != file || e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+
+				if err := d.reload(); err != nil {
+					d.log.Op("config").Warnf("ignoring the changes to the config file, they are invalid: %v", err)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+
+				d.log.Op("config").Warnf("error while watching the config file: %v", err)
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
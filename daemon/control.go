@@ -0,0 +1,500 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/nikochiko/autosaved/core"
+	viperPkg "github.com/spf13/viper"
+)
+
+// the commands understood on the control socket
+const (
+	CommandStatus  = "status"
+	CommandSave    = "save"
+	CommandPause   = "pause"
+	CommandResume  = "resume"
+	CommandWatch   = "watch"
+	CommandUnwatch = "unwatch"
+	CommandReload  = "reload"
+)
+
+// controlTimeout bounds how long a connection to the control socket may take,
+// a save included
+const controlTimeout = time.Minute
+
+var (
+	ErrUnknownCommand     = errors.New("the daemon doesn't know this command")
+	ErrRepoNotWatched     = errors.New("the repository isn't being watched")
+	ErrAlreadyWatched     = errors.New("the repository is already being watched")
+	ErrNotAGitRepo        = errors.New("the path should have a Git repository")
+	ErrPathNotAbsolute    = errors.New("the path of the repository should be absolute")
+	ErrRepoNeedsAttention = errors.New("the repository needs attention before it can be saved, see `autosaved status`")
+)
+
+// remoteErrors are turned back into themselves by Call, so that clients can
+// check for them with errors.Is
+var remoteErrors = []error{
+	ErrUnknownCommand, ErrRepoNotWatched, ErrAlreadyWatched, ErrNotAGitRepo, ErrPathNotAbsolute,
+	ErrDaemonNotRunning, core.ErrNothingToSave,
+}
+
+// Request is sent to the daemon on the control socket, as a line of JSON
+type Request struct {
+	Command string `json:"command"`
+	// Path is the repository for save, watch and unwatch
+	Path string `json:"path,omitempty"`
+	// Message is the message of the checkpoint made by save
+	Message string `json:"message,omitempty"`
+}
+
+// Response is the daemon's answer to a Request, as a line of JSON
+type Response struct {
+	Error string `json:"error,omitempty"`
+	// Status is set for the status command
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes what the daemon is doing
+type Status struct {
+	Running   bool       `json:"running"`
+	PID       int        `json:"pid,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Paused    bool       `json:"paused"`
+	// CheckingInterval is in seconds, like in the config file
+	CheckingInterval int          `json:"checking_interval"`
+	AfterEvery       AfterEvery   `json:"after_every"`
+	Repositories     []RepoStatus `json:"repositories"`
+}
+
+// AfterEvery is the effective after_every setting
+type AfterEvery struct {
+	Seconds int `json:"seconds"`
+	Words   int `json:"words,omitempty"`
+	Lines   int `json:"lines,omitempty"`
+	Files   int `json:"files,omitempty"`
+}
+
+// RepoStatus describes one of the watched repositories. The times are nil
+// when that hasn't happened yet.
+type RepoStatus struct {
+	Path string `json:"path"`
+	// Watching is false when the repository's files can't be watched and it
+	// is polled instead
+	Watching       bool       `json:"watching"`
+	Checking       bool       `json:"checking"`
+	LastCheck      *time.Time `json:"last_check,omitempty"`
+	LastSave       *time.Time `json:"last_save,omitempty"`
+	LastSkipReason string     `json:"last_skip_reason,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+	// Failures counts the checks that failed in a row
+	Failures int `json:"failures,omitempty"`
+	// the counters go back to when the state file was created
+	Checks      int         `json:"checks"`
+	Saves       int         `json:"saves"`
+	Skips       int         `json:"skips"`
+	Errors      int         `json:"errors"`
+	SaveHistory []time.Time `json:"save_history,omitempty"`
+	// Attention is set when the repository isn't autosaved until the user
+	// fixes it
+	Attention string `json:"needs_attention,omitempty"`
+	// NextCheck is nil when the next check waits for files to change
+	NextCheck *time.Time `json:"next_check,omitempty"`
+}
+
+// StatusFromConfig describes a daemon that isn't running, from its config
+func StatusFromConfig(v *viperPkg.Viper) *Status {
+	s := &Status{
+		CheckingInterval: getIntOrDefault(v, checkingIntervalKey, defaultCheckingInterval),
+		AfterEvery: AfterEvery{
+			Seconds: getMinimumSeconds(v.GetInt(afterMinutesKey), v.GetInt(afterSecondsKey)),
+			Words:   v.GetInt(afterEveryKey + ".words"),
+			Lines:   v.GetInt(afterEveryKey + ".lines"),
+			Files:   v.GetInt(afterEveryKey + ".files"),
+		},
+	}
+
+	for _, path := range v.GetStringSlice(reposKey) {
+		s.Repositories = append(s.Repositories, RepoStatus{Path: path})
+	}
+
+	return s
+}
+
+// Owner returns the process of the running daemon, from the lockfile
+func Owner(lockfilePath string) (*os.Process, error) {
+	lock, err := lockfile.New(lockfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	proc, err := lock.GetOwner()
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, lockfile.ErrDeadOwner) {
+			return nil, ErrDaemonNotRunning
+		}
+
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+// SocketPath returns the path of the control socket, which lives next to the
+// lockfile
+func SocketPath(lockfilePath string) string {
+	return lockfilePath + ".sock"
+}
+
+// Call sends a request to the running daemon. It returns ErrDaemonNotRunning
+// if nothing is listening on the control socket.
+func Call(lockfilePath string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(lockfilePath), time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDaemonNotRunning, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp Response
+	if err = json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("couldn't read the daemon's response: %w", err)
+	}
+
+	if resp.Error != "" {
+		for _, e := range remoteErrors {
+			if resp.Error == e.Error() {
+				return &resp, e
+			}
+		}
+
+		return &resp, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// listenControl starts serving the control socket. It must be called with the
+// lockfile held, so a socket file left behind is known to be stale.
+func (d *Daemon) listenControl() (net.Listener, error) {
+	path := SocketPath(d.lockfilePath)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the user running the daemon may control it
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if d.ctx.Err() == nil {
+					d.log.Op("control").Warnf("stopped listening on the control socket: %v", err)
+				}
+				return
+			}
+
+			go d.serveControl(conn)
+		}
+	}()
+
+	return l, nil
+}
+
+// serveControl answers one request
+func (d *Daemon) serveControl(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		d.log.Op("control").Warnf("bad request on the control socket: %v", err)
+		return
+	}
+
+	log := d.log.Op("control")
+	if req.Path != "" {
+		log = log.Repo(req.Path)
+	}
+	log.Debugf("control request %q", req.Command)
+
+	resp, err := d.handleControl(req)
+	if err != nil {
+		resp = &Response{Error: err.Error()}
+	}
+
+	if err = json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Warnf("couldn't answer on the control socket: %v", err)
+	}
+}
+
+func (d *Daemon) handleControl(req Request) (*Response, error) {
+	switch req.Command {
+	case CommandStatus:
+		return &Response{Status: d.status()}, nil
+	case CommandSave:
+		return &Response{}, d.saveNow(req.Path, req.Message)
+	case CommandPause:
+		atomic.StoreInt32(&d.paused, 1)
+		d.log.Op("control").Infof("paused, no repository will be checked until resumed")
+		return &Response{}, nil
+	case CommandResume:
+		if atomic.CompareAndSwapInt32(&d.paused, 1, 0) {
+			d.log.Op("control").Infof("resumed")
+			// pick up what changed while paused
+			d.CheckAllRepos()
+		}
+		return &Response{}, nil
+	case CommandWatch:
+		return &Response{}, d.setWatched(req.Path, true)
+	case CommandUnwatch:
+		return &Response{}, d.setWatched(req.Path, false)
+	case CommandReload:
+		return &Response{}, d.reload()
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownCommand, req.Command)
+}
+
+// isPaused reports whether checks are paused
+func (d *Daemon) isPaused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+func (d *Daemon) status() *Status {
+	cfg := d.currentConfig()
+
+	startedAt := d.startedAt
+	s := &Status{
+		Running:          true,
+		PID:              os.Getpid(),
+		StartedAt:        &startedAt,
+		Paused:           d.isPaused(),
+		CheckingInterval: int(cfg.checkingInterval / time.Second),
+		AfterEvery: AfterEvery{
+			Seconds: cfg.minSeconds,
+			Words:   cfg.afterThresholds.Words,
+			Lines:   cfg.afterThresholds.Lines,
+			Files:   cfg.afterThresholds.Files,
+		},
+	}
+
+	d.mu.Lock()
+	for path := range cfg.repositories {
+		rs := RepoStatus{Path: path}
+		_, rs.Checking = d.busy[path]
+
+		var next time.Time
+		rw, ok := d.watchers[path]
+		rs.Watching = ok && !rw.hasFailed()
+		if rs.Watching {
+			next = rw.nextCheck()
+		}
+
+		if a, ok := d.activity[path]; ok {
+			rs.LastCheck = timeOrNil(a.LastCheck)
+			rs.LastSave = timeOrNil(a.LastSave)
+			rs.LastSkipReason = a.LastSkipReason
+			rs.LastError = a.LastError
+			rs.LastErrorAt = timeOrNil(a.LastErrorAt)
+			rs.Checks = a.Checks
+			rs.Saves = a.Saves
+			rs.Skips = a.Skips
+			rs.Errors = a.Errors
+			rs.SaveHistory = append([]time.Time(nil), a.SaveHistory...)
+			rs.Attention = a.Attention
+
+			if !rs.Watching {
+				next = a.LastCheck.Add(cfg.pollingInterval())
+			}
+		}
+
+		if !rs.Watching && next.IsZero() {
+			// it's queued to be polled
+			next = time.Now()
+		}
+
+		if f, ok := d.failures[path]; ok {
+			rs.Failures = f.count
+			next = f.retryAt
+		}
+
+		if !s.Paused {
+			rs.NextCheck = timeOrNil(next)
+		}
+
+		s.Repositories = append(s.Repositories, rs)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(s.Repositories, func(i, j int) bool {
+		return s.Repositories[i].Path < s.Repositories[j].Path
+	})
+
+	return s
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// saveNow saves a watched repository right away, whether or not it is due
+func (d *Daemon) saveNow(path, msg string) error {
+	repo, ok := d.currentConfig().repositories[path]
+	if !ok {
+		return ErrRepoNotWatched
+	}
+
+	d.mu.Lock()
+	if d.ctx.Err() != nil {
+		d.mu.Unlock()
+		return ErrDaemonNotRunning
+	}
+	d.running.Add(1)
+	d.mu.Unlock()
+	defer d.running.Done()
+
+	unlock := d.lockRepo(path)
+	defer unlock()
+
+	if !d.recoverRepo(path, repo) {
+		return ErrRepoNeedsAttention
+	}
+
+	d.log.Repo(path).Op("save").Infof("saving on request")
+	if err := repo.SaveContext(d.ctx, msg); err != nil {
+		return err
+	}
+
+	d.recordSave(path)
+	d.metrics.save(path)
+	d.writeState()
+	return nil
+}
+
+// setWatched adds the repository to the config file or removes it, and
+// reloads the config
+func (d *Daemon) setWatched(path string, watched bool) error {
+	if !filepath.IsAbs(path) {
+		return ErrPathNotAbsolute
+	}
+
+	if watched {
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			return fmt.Errorf("%w: %v", ErrNotAGitRepo, err)
+		}
+	}
+
+	d.viperMu.Lock()
+	defer d.viperMu.Unlock()
+
+	// the file is edited through a viper of its own, since setting the
+	// repositories on d.viper would hide later edits of the file
+	v := viperPkg.New()
+	v.SetConfigFile(d.viper.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	var updated []string
+	found := false
+	for _, r := range v.GetStringSlice(reposKey) {
+		if r == path {
+			found = true
+			if !watched {
+				continue
+			}
+		}
+
+		updated = append(updated, r)
+	}
+
+	switch {
+	case watched && found:
+		return ErrAlreadyWatched
+	case !watched && !found:
+		return ErrRepoNotWatched
+	case watched:
+		updated = append(updated, path)
+	}
+
+	v.Set(reposKey, updated)
+	if err := v.WriteConfig(); err != nil {
+		return err
+	}
+
+	// don't wait for the config file to be noticed as changed
+	return d.reloadLocked()
+}
+
+// reload reads the config file again and switches over to it, if it's valid
+func (d *Daemon) reload() error {
+	d.viperMu.Lock()
+	defer d.viperMu.Unlock()
+
+	return d.reloadLocked()
+}
+
+// reloadLocked checks the config file with a viper of its own, and only
+// gives its contents to d.viper once they are known to be valid, so that an
+// invalid file changes nothing. viperMu must be held.
+func (d *Daemon) reloadLocked() error {
+	file := d.viper.ConfigFileUsed()
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	configType := strings.TrimPrefix(filepath.Ext(file), ".")
+	if configType == "" {
+		configType = "yaml"
+	}
+
+	v := viperPkg.New()
+	v.SetConfigType(configType)
+	if err = v.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return err
+	}
+
+	if _, err = d.readConfig(v); err != nil {
+		return err
+	}
+
+	// the same contents, so that a later write of the file can't slip in
+	if err = d.viper.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return err
+	}
+
+	return d.loadConfigLocked()
+}
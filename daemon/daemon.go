@@ -5,15 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/nikochiko/autosaved/core"
-	"github.com/fsnotify/fsnotify"
 	"github.com/nightlyone/lockfile"
-	"github.com/spf13/viper"
+	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/logging"
 	viperPkg "github.com/spf13/viper"
 )
 
@@ -25,6 +27,31 @@ const (
 
 	afterMinutesKey = "after_every.minutes"
 	afterSecondsKey = "after_every.seconds"
+	afterEveryKey   = "after_every"
+	regardlessKey   = "regardless_of_time"
+
+	retentionKey = "retention"
+
+	debounceKey            = "debounce_seconds"
+	defaultDebounceSeconds = 5
+
+	workersKey     = "workers"
+	defaultWorkers = 4
+
+	checkTimeoutKey            = "check_timeout_seconds"
+	defaultCheckTimeoutSeconds = 300
+
+	logLevelKey  = "log_level"
+	logFormatKey = "log_format"
+	// the log goes to stderr unless logFileKey is set
+	logFileKey              = "log_file"
+	logFileMaxSizeKey       = "log_file_max_size_mb"
+	defaultLogFileMaxSize   = 10
+	logFileMaxBackupsKey    = "log_file_max_backups"
+	defaultLogFileMaxBackup = 3
+
+	// metrics are only served when metricsAddressKey is set
+	metricsAddressKey = "metrics_address"
 )
 
 func getMinimumSeconds(minutes, seconds int) int {
@@ -35,47 +62,134 @@ var (
 	ErrCheckingIntervalNegative = errors.New("negative checking interval is not allowed")
 	ErrDaemonAlreadyRunning     = errors.New("it seems like the autosave daemon is already running")
 	ErrDaemonNotRunning         = errors.New("it seems like the autosave daemon is not running")
+	ErrRetentionNegative        = errors.New("negative values are not allowed in the retention config")
+	ErrThresholdNegative        = errors.New("negative words, lines or files thresholds are not allowed")
+	ErrDebounceNegative         = errors.New("negative debounce window is not allowed")
+	ErrWorkersNotPositive       = errors.New("at least one worker is needed to check repositories")
+	ErrCheckTimeoutNegative     = errors.New("negative check timeout is not allowed")
+	ErrLogFileLimitsNegative    = errors.New("negative log file size or number of backups is not allowed")
+	ErrBadMetricsAddress        = errors.New("metrics_address should be written like 127.0.0.1:9810")
+	ErrMetricsNotLocal          = errors.New("metrics are only served on localhost, metrics_address needs a loopback host")
 )
 
+// loadChangeThresholds reads the words, lines and files thresholds under key
+func loadChangeThresholds(v *viperPkg.Viper, key string) (core.ChangeThresholds, error) {
+	t := core.ChangeThresholds{
+		Words: v.GetInt(key + ".words"),
+		Lines: v.GetInt(key + ".lines"),
+		Files: v.GetInt(key + ".files"),
+	}
+
+	if t.Words < 0 || t.Lines < 0 || t.Files < 0 {
+		return core.ChangeThresholds{}, ErrThresholdNegative
+	}
+
+	return t, nil
+}
+
+// retentionConfig is the `retention` section of the config file
+type retentionConfig struct {
+	MaxCount int           `mapstructure:"max_count"`
+	MaxAge   time.Duration `mapstructure:"max_age"`
+	Tiers    []struct {
+		Within time.Duration `mapstructure:"within"`
+		Every  time.Duration `mapstructure:"every"`
+	} `mapstructure:"tiers"`
+}
+
+// LoadRetentionPolicy reads the `retention` section of the config. Durations
+// are written like "90m" or "720h".
+func LoadRetentionPolicy(v *viperPkg.Viper) (core.RetentionPolicy, error) {
+	var cfg retentionConfig
+	if err := v.UnmarshalKey(retentionKey, &cfg); err != nil {
+		return core.RetentionPolicy{}, err
+	}
+
+	if cfg.MaxCount < 0 || cfg.MaxAge < 0 {
+		return core.RetentionPolicy{}, ErrRetentionNegative
+	}
+
+	p := core.RetentionPolicy{MaxCount: cfg.MaxCount, MaxAge: cfg.MaxAge}
+	for _, t := range cfg.Tiers {
+		p.Tiers = append(p.Tiers, core.RetentionTier{Within: t.Within, Every: t.Every})
+	}
+
+	return p, p.Validate()
+}
+
 type Daemon struct {
 	viper        *viperPkg.Viper
 	lockfilePath string
 	errWriter    io.Writer
 	outWriter    io.Writer
 
+	// log writes to errWriter, or to the log file when there is one.
+	// logMu guards logFile and logOutput, which is what log writes to.
+	log       *logging.Logger
+	logMu     sync.Mutex
+	logFile   *logging.RotatingFile
+	logOutput logOutput
+
+	// configUpdateChannel wakes up the main loop after a reload. It has room
+	// for one update, further ones are merged into it.
 	configUpdateChannel chan bool
-	started             bool
 	ctx                 context.Context
 	cancel              context.CancelFunc
 
-	checkingInterval time.Duration
-	repositories     map[string]*core.AsdRepository
-
-	minSeconds int
-}
-
-func (d *Daemon) setCheckingIntervalSeconds(s int) error {
-	if s < 0 {
-		return ErrCheckingIntervalNegative
-	}
-
-	d.checkingInterval = time.Duration(s) * time.Second
-	return nil
+	// config holds the *config in use, viperMu serializes reading viper
+	config  atomic.Value
+	viperMu sync.Mutex
+
+	startedAt time.Time
+	// paused is 1 while checks are paused from the control socket
+	paused int32
+
+	// watchers tell readyChannel when a repository's files have settled.
+	// Repositories without a working watcher are polled instead.
+	watchers     map[string]*repoWatcher
+	readyChannel chan string
+
+	// mu guards the maps below and workerSlots, which are used by the
+	// workers as well as the main loop
+	mu sync.Mutex
+	// failures holds the repositories whose last checks failed
+	failures map[string]*repoFailure
+	// busy holds the repositories being checked, and whether they need to be
+	// checked again after that
+	busy map[string]bool
+	// staggered holds the timers of checks spread over the checking
+	// interval, so that they can be stopped
+	staggered map[string]*time.Timer
+	// workerSlots has room for as many checks as there are workers
+	workerSlots chan struct{}
+	// running counts the checks that have been started
+	running sync.WaitGroup
+	// repoLocks keep checks and saves requested on the control socket from
+	// working on the same repository at once
+	repoLocks map[string]*sync.Mutex
+	// activity is kept in the state file, stateMu serializes writing it
+	activity map[string]*repoActivity
+	stateMu  sync.Mutex
+
+	metrics *metrics
+	// the metrics listener is only touched by the main loop
+	metricsAddress string
+	metricsServer  *http.Server
 }
 
 func (d *Daemon) CheckingInterval() time.Duration {
-	return d.checkingInterval
+	return d.currentConfig().checkingInterval
 }
 
+// Repositories returns the repositories being watched. The map must not be
+// modified.
 func (d *Daemon) Repositories() map[string]*core.AsdRepository {
-	return d.repositories
+	return d.currentConfig().repositories
 }
 
 func (d *Daemon) Start() error {
 	go d.listenForAndHandleClosingSignals()
 
-	d.started = true
-
 	defer func() {
 		// teardown before exiting
 		d.teardown()
@@ -98,147 +212,317 @@ func (d *Daemon) Start() error {
 
 	defer func() {
 		if err = lock.Unlock(); err != nil {
-			fmt.Fprintf(d.errWriter, "unable to unlock lockfile due to err: %v\n", err)
+			d.log.Errorf("unable to unlock lockfile due to err: %v", err)
 		} else {
 			err = os.Remove(d.lockfilePath)
 			if err == nil {
-				fmt.Fprintf(d.errWriter, "Debug: removed lockfile")
+				d.log.Debugf("removed lockfile")
 			}
 		}
 	}()
 
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		d.LoadConfig()
-	})
+	d.startedAt = time.Now()
+	// with the lockfile held, this is the only daemon using the state file
+	d.loadState()
+
+	if err = d.watchConfigFile(); err != nil {
+		d.log.Op("config").Warnf("changes to the config file won't be noticed, use `autosaved reload` after making them: %v", err)
+	}
+
+	control, err := d.listenControl()
+	if err != nil {
+		return fmt.Errorf("couldn't listen on the control socket: %w", err)
+	}
+	defer control.Close()
+
+	d.syncMetricsServer()
+
+	d.resizeWorkerPool()
+	d.syncWatchers()
+
+	// fix what a crash may have left behind before anything else
+	d.recoverAll()
+
+	// pick up whatever changed while the daemon wasn't running
+	d.CheckAllRepos()
+
+	ticker := time.NewTicker(d.currentConfig().pollingInterval())
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-d.configUpdateChannel:
 			// config was updated, go over the repositories again
-			err := d.CheckAllRepos()
-			if err != nil {
-				return err
-			}
-		case <-time.After(d.checkingInterval):
-			err := d.CheckAllRepos()
-			if err != nil {
-				return err
+			d.stopStaggered()
+			d.resizeWorkerPool()
+			d.syncWatchers()
+			d.syncMetricsServer()
+			ticker.Reset(d.currentConfig().pollingInterval())
+
+			d.CheckAllRepos()
+		case path := <-d.readyChannel:
+			repo, ok := d.currentConfig().repositories[path]
+			if !ok {
+				continue
 			}
+
+			d.enqueue(path, repo)
+		case <-ticker.C:
+			d.CheckPolledRepos()
+			d.retryFailedRepos()
 		case <-d.ctx.Done():
-			fmt.Fprintf(d.errWriter, "Info: Gracefully shutting down daemon\n")
+			d.log.Infof("gracefully shutting down daemon")
 			return nil
 		}
 	}
-
-	return nil
 }
 
 func (d *Daemon) Stop() error {
-	// check for lockfile
-	lock, err := lockfile.New(d.lockfilePath)
+	proc, err := Owner(d.lockfilePath)
 	if err != nil {
 		return err
 	}
 
-	proc, err := lock.GetOwner()
-	if err != nil {
-		if os.IsNotExist(err) || errors.Is(err, lockfile.ErrDeadOwner) {
-			return ErrDaemonNotRunning
+	err = proc.Signal(syscall.SIGTERM)
+	return err
+}
+
+// CheckAllRepos queues checks of every repository, spread over the checking
+// interval. Failures are recorded per repository and don't stop the others
+// from being checked.
+func (d *Daemon) CheckAllRepos() {
+	d.log.Op("check").Infof("checking all repositories")
+
+	repos := d.currentConfig().repositories
+	d.metrics.setWatched(len(repos))
+	d.enqueueStaggered(repos)
+}
+
+// CheckPolledRepos queues checks of the repositories whose files can't be
+// watched
+func (d *Daemon) CheckPolledRepos() {
+	polled := make(map[string]*core.AsdRepository)
+
+	d.mu.Lock()
+	for path, repo := range d.currentConfig().repositories {
+		if rw, ok := d.watchers[path]; ok && !rw.hasFailed() {
+			continue
 		}
 
-		return err
+		polled[path] = repo
+	}
+	d.mu.Unlock()
+
+	for path := range polled {
+		d.log.Repo(path).Op("poll").Debugf("polling repository")
+	}
+
+	d.enqueueStaggered(polled)
+}
+
+func (d *Daemon) checkRepoIfChanged(ctx context.Context, path string, repo *core.AsdRepository) error {
+	err := d.CheckRepo(ctx, path, repo)
+	if errors.Is(err, core.ErrNothingToSave) {
+		d.log.Repo(path).Op("save").Infof("nothing to save")
+		return nil
 	}
 
-	err = proc.Signal(syscall.SIGTERM)
 	return err
 }
 
-func (d *Daemon) CheckAllRepos() error {
-	fmt.Fprintf(d.errWriter, "Info: checking all repositories\n")
+// CheckRepo saves the repository if it should be saved, and prunes it. It
+// gives up when ctx is done.
+func (d *Daemon) CheckRepo(ctx context.Context, path string, asdRepo *core.AsdRepository) (err error) {
+	start := time.Now()
+	defer func() {
+		failed := err != nil && !errors.Is(err, core.ErrNothingToSave)
+		d.metrics.check(path, time.Since(start), failed)
+	}()
+
+	shouldSave, reason, err := asdRepo.ShouldSaveContext(ctx)
+	if err != nil {
+		return err
+	}
 
-	for path, repo := range d.repositories {
-		err := d.CheckRepo(path, repo)
+	if shouldSave {
+		d.log.Repo(path).Op("save").Infof("autosaving repository")
+		err = asdRepo.SaveContext(ctx, reason)
 		if err != nil {
 			if errors.Is(err, core.ErrNothingToSave) {
-				fmt.Fprintf(d.errWriter, "Info: Nothing to save in %s\n", path)
-				continue
+				d.recordSkip(path, err.Error())
+				d.metrics.skip(path, err.Error())
 			}
 			return err
 		}
+		d.recordSave(path)
+		d.metrics.save(path)
+	} else {
+		d.log.Repo(path).Op("check").Debugf("shouldn't save because of reason: %s", reason)
+		d.recordSkip(path, reason)
+		d.metrics.skip(path, reason)
+		d.scheduleRecheck(path, asdRepo)
 	}
 
+	d.pruneRepo(path, asdRepo)
 	return nil
 }
 
-func (d *Daemon) CheckRepo(path string, asdRepo *core.AsdRepository) error {
-	shouldSave, reason, err := asdRepo.ShouldSave()
-	if err != nil {
-		return err
+// scheduleRecheck makes the watcher of a repository check it again once the
+// minimum time between saves has passed, since edits made before that
+// wouldn't trigger another check by themselves
+func (d *Daemon) scheduleRecheck(path string, asdRepo *core.AsdRepository) {
+	d.mu.Lock()
+	rw, ok := d.watchers[path]
+	d.mu.Unlock()
+
+	if !ok || rw.hasFailed() {
+		return
 	}
 
-	if shouldSave {
-		fmt.Fprintf(d.errWriter, "Info: autosaving repository %s\n", path)
-		return asdRepo.Save(reason)
+	next, err := asdRepo.NextSaveTime()
+	if err != nil || !next.After(time.Now()) {
+		return
 	}
 
-	fmt.Fprintf(d.errWriter, "Debug: shouldn't save repo '%s' because of reason: %s\n", path, reason)
-	return nil
+	rw.recheckAt(next)
 }
 
-func (d *Daemon) LoadConfig() error {
-	var checkingInterval int
-	if !d.viper.IsSet(checkingIntervalKey) {
-		viper.Set(checkingIntervalKey, defaultCheckingInterval)
+// syncWatchers starts watching the files of new repositories and stops
+// watching removed ones
+func (d *Daemon) syncWatchers() {
+	cfg := d.currentConfig()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for path, rw := range d.watchers {
+		if _, ok := cfg.repositories[path]; !ok || rw.hasFailed() {
+			rw.close()
+			delete(d.watchers, path)
+		}
+	}
+
+	for path := range cfg.repositories {
+		if rw, ok := d.watchers[path]; ok {
+			rw.setDebounceWindow(cfg.debounce)
+			continue
+		}
+
+		rw, err := newRepoWatcher(d.ctx, path, cfg.debounce, d.readyChannel, d.log)
+		if err != nil {
+			d.log.Repo(path).Op("watch").Warnf("couldn't watch files, polling the repository every %s instead: %v", cfg.checkingInterval, err)
+			continue
+		}
+
+		d.watchers[path] = rw
+	}
+}
+
+// resizeWorkerPool makes room for the configured number of workers. Checks
+// that are already running finish in the old pool.
+func (d *Daemon) resizeWorkerPool() {
+	workers := d.currentConfig().workers
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.workerSlots == nil || cap(d.workerSlots) != workers {
+		d.workerSlots = make(chan struct{}, workers)
 	}
+}
 
-	checkingInterval = d.viper.GetInt(checkingIntervalKey)
-	err := d.setCheckingIntervalSeconds(checkingInterval)
+// pruneRepo applies the retention policy to a repository. Failing to prune
+// isn't fatal, saving is more important than cleaning up.
+func (d *Daemon) pruneRepo(path string, asdRepo *core.AsdRepository) {
+	results, err := asdRepo.Prune(core.PruneOptions{})
 	if err != nil {
-		return err
+		d.log.Repo(path).Op("prune").Warnf("couldn't prune checkpoints: %v", err)
+		return
+	}
+
+	for _, result := range results {
+		d.log.Repo(path).Op("prune").Infof("pruned %d checkpoints from %s", len(result.Dropped), result.Ref)
 	}
+}
 
-	afterMinutes := d.viper.GetInt(afterMinutesKey)
-	afterSeconds := d.viper.GetInt(afterSecondsKey)
-	d.minSeconds = getMinimumSeconds(afterMinutes, afterSeconds)
+// LoadConfig reads the configuration and switches the daemon over to it. An
+// invalid configuration is rejected as a whole, and the one in use is kept.
+func (d *Daemon) LoadConfig() error {
+	d.viperMu.Lock()
+	defer d.viperMu.Unlock()
 
-	repos := d.viper.GetStringSlice(reposKey)
+	return d.loadConfigLocked()
+}
 
-	asdRepos := make(map[string]*core.AsdRepository)
-	for _, path := range repos {
-		asdRepo, err := core.AsdRepoFromGitRepoPath(path, d.minSeconds)
-		if err != nil {
-			fmt.Fprintf(d.errWriter, "Warning: Git repo at %s couldn't be initialised due to error: %v\n", path, err)
-		} else {
-			asdRepos[path] = asdRepo
-		}
+// loadConfigLocked is LoadConfig with viperMu already held
+func (d *Daemon) loadConfigLocked() error {
+	cfg, err := d.readConfig(d.viper)
+	if err != nil {
+		return err
 	}
-	d.repositories = asdRepos
+	d.openRepositories(d.viper, cfg)
+
+	d.config.Store(cfg)
+	d.applyLogConfig(cfg)
 
-	if d.started {
-		d.configUpdateChannel <- true
+	// never blocks, if an update is already pending the main loop will see
+	// this config when it handles that one
+	select {
+	case d.configUpdateChannel <- true:
+	default:
 	}
 
 	return nil
 }
 
-// teardown does some necessary cleanup, like closing channels
+// teardown stops the watchers and waits for running checks. The channels are
+// left open, so that late reloads or watcher events can't panic.
 func (d *Daemon) teardown() {
 	d.cancel()
-	close(d.configUpdateChannel)
-	d.started = false
+	d.stopStaggered()
+
+	// no check can start after the context is cancelled, so wait for the
+	// running ones to give up
+	d.mu.Lock()
+	for path, rw := range d.watchers {
+		rw.close()
+		delete(d.watchers, path)
+	}
+	d.mu.Unlock()
+	d.running.Wait()
+	d.stopMetricsServer()
+
+	d.writeState()
+	d.closeLogFile()
 }
 
-func New(viper *viperPkg.Viper, lockfilePath string, wOut, wErr io.Writer, minSeconds int) (*Daemon, error) {
+func New(viper *viperPkg.Viper, lockfilePath string, wOut, wErr io.Writer) (*Daemon, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	d := &Daemon{viper: viper, lockfilePath: lockfilePath, errWriter: wErr, outWriter: wOut, ctx: ctx, cancel: cancel, minSeconds: minSeconds}
-	err := d.LoadConfig()
+	d := &Daemon{viper: viper, lockfilePath: lockfilePath, errWriter: wErr, outWriter: wOut, ctx: ctx, cancel: cancel}
+	d.log = logging.New(wErr, logging.LevelInfo, logging.FormatLogfmt).Op("daemon")
+
+	d.viperMu.Lock()
+	cfg, err := d.readConfig(d.viper)
+	if err == nil {
+		d.openRepositories(d.viper, cfg)
+	}
+	d.viperMu.Unlock()
 	if err != nil {
 		return nil, err
 	}
-
-	d.configUpdateChannel = make(chan bool)
+	d.config.Store(cfg)
+	d.applyLogConfig(cfg)
+
+	d.configUpdateChannel = make(chan bool, 1)
+	d.readyChannel = make(chan string)
+	d.watchers = make(map[string]*repoWatcher)
+	d.failures = make(map[string]*repoFailure)
+	d.busy = make(map[string]bool)
+	d.staggered = make(map[string]*time.Timer)
+	d.repoLocks = make(map[string]*sync.Mutex)
+	d.activity = make(map[string]*repoActivity)
+	d.metrics = newMetrics()
 
 	return d, nil
 }
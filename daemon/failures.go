@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nikochiko/autosaved/core"
+)
+
+const (
+	// the first retry of a failing repository is after minBackoff, and the
+	// wait doubles with each further failure up to maxBackoff
+	minBackoff = 30 * time.Second
+	maxBackoff = time.Hour
+)
+
+// repoFailure records a repository that failed its last checks
+type repoFailure struct {
+	err         error
+	count       int
+	since       time.Time
+	retryAt     time.Time
+	fingerprint string
+}
+
+// shouldRetry reports whether a failing repository is worth checking again:
+// its backoff has passed, or HEAD or the index changed since it failed
+func (f *repoFailure) shouldRetry(now time.Time, fingerprint string) bool {
+	return !now.Before(f.retryAt) || fingerprint != f.fingerprint
+}
+
+func backoff(count int) time.Duration {
+	wait := minBackoff
+	for i := 1; i < count && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	return wait
+}
+
+// checkRepoSafely checks a repository, turning panics into errors and
+// recording failures, so that no repository can stop the daemon or the
+// checks of the others. Repositories in backoff are skipped.
+func (d *Daemon) checkRepoSafely(path string, repo *core.AsdRepository) {
+	now := time.Now()
+
+	// the fingerprint reads the repository, so it is taken under its lock
+	unlock := d.lockRepo(path)
+	fingerprint := repo.StateFingerprint()
+
+	d.mu.Lock()
+	f, failing := d.failures[path]
+	if failing && !f.shouldRetry(now, fingerprint) {
+		d.log.Repo(path).Op("check").Debugf("skipping until %s, it failed %d times: %v", f.retryAt.Format(time.RFC3339), f.count, f.err)
+		d.mu.Unlock()
+		unlock()
+		return
+	}
+	timeout := d.currentConfig().checkTimeout
+	d.mu.Unlock()
+
+	if !d.recoverRepo(path, repo) {
+		unlock()
+		return
+	}
+	err := d.checkRepoRecovering(path, repo, timeout)
+	unlock()
+
+	if err != nil && d.ctx.Err() != nil {
+		// the daemon is shutting down, that isn't the repository's fault
+		return
+	}
+
+	d.recordCheck(path, err)
+	defer d.writeState()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		if f, ok := d.failures[path]; ok {
+			d.log.Repo(path).Op("check").Infof("working again after failing %d times", f.count)
+			delete(d.failures, path)
+		}
+
+		return
+	}
+
+	f, ok := d.failures[path]
+	if !ok {
+		f = &repoFailure{since: now}
+		d.failures[path] = f
+	}
+
+	f.err = err
+	f.count++
+	f.retryAt = now.Add(backoff(f.count))
+	f.fingerprint = fingerprint
+
+	d.log.Repo(path).Op("check").Warnf("failed (%d times in a row), retrying at %s or when HEAD or the index change: %v", f.count, f.retryAt.Format(time.RFC3339), err)
+}
+
+// checkRepoRecovering checks a repository within the timeout, if there is
+// one, and turns panics into errors
+func (d *Daemon) checkRepoRecovering(path string, repo *core.AsdRepository, timeout time.Duration) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	ctx := d.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err = d.checkRepoIfChanged(ctx, path, repo)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("gave up after %s, %s can be raised for big repositories: %w", timeout, checkTimeoutKey, err)
+	}
+
+	return err
+}
+
+// retryFailedRepos queues checks of the failing repositories that are due
+// for a retry. Repositories a worker is on already are left to it.
+func (d *Daemon) retryFailedRepos() {
+	now := time.Now()
+
+	due := make(map[string]*core.AsdRepository)
+	waiting := make(map[string]*core.AsdRepository)
+
+	d.mu.Lock()
+	for path, f := range d.failures {
+		repo, ok := d.currentConfig().repositories[path]
+		if !ok {
+			delete(d.failures, path)
+			continue
+		}
+
+		if _, busy := d.busy[path]; busy {
+			continue
+		}
+
+		if !now.Before(f.retryAt) {
+			due[path] = repo
+		} else {
+			waiting[path] = repo
+		}
+	}
+	d.mu.Unlock()
+
+	// the others are retried early if HEAD or the index changed, which is
+	// read under the repository's lock, without holding d.mu
+	for path, repo := range waiting {
+		unlock := d.lockRepo(path)
+		fingerprint := repo.StateFingerprint()
+		unlock()
+
+		d.mu.Lock()
+		if f, ok := d.failures[path]; ok && f.shouldRetry(now, fingerprint) {
+			due[path] = repo
+		}
+		d.mu.Unlock()
+	}
+
+	for path, repo := range due {
+		d.enqueue(path, repo)
+	}
+}
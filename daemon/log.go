@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/nikochiko/autosaved/core"
+	"github.com/nikochiko/autosaved/logging"
+	viperPkg "github.com/spf13/viper"
+)
+
+// logOutput is where the log goes and how it's written
+type logOutput struct {
+	format logging.Format
+	// file is empty for errWriter
+	file       string
+	maxSize    int64
+	maxBackups int
+}
+
+func readLogOutput(v *viperPkg.Viper) (logOutput, error) {
+	format, err := logging.ParseFormat(getStringOrDefault(v, logFormatKey, "logfmt"))
+	if err != nil {
+		return logOutput{}, err
+	}
+
+	out := logOutput{
+		format:     format,
+		file:       v.GetString(logFileKey),
+		maxSize:    int64(getIntOrDefault(v, logFileMaxSizeKey, defaultLogFileMaxSize)) << 20,
+		maxBackups: getIntOrDefault(v, logFileMaxBackupsKey, defaultLogFileMaxBackup),
+	}
+
+	if out.maxSize < 0 || out.maxBackups < 0 {
+		return logOutput{}, ErrLogFileLimitsNegative
+	}
+
+	if out.file != "" {
+		if out.file, err = filepath.Abs(out.file); err != nil {
+			return logOutput{}, err
+		}
+	}
+
+	return out, nil
+}
+
+// applyLogConfig switches the log over to the level and output of cfg. If
+// the log file can't be opened, the log keeps going where it went before.
+func (d *Daemon) applyLogConfig(cfg *config) {
+	d.log.SetLevel(cfg.logLevel)
+	// lines logged by core, like saves, go to the same place
+	core.SetLogger(d.log)
+
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	if cfg.logOutput == d.logOutput {
+		return
+	}
+
+	if cfg.logOutput.file == "" {
+		d.log.SetOutput(d.errWriter, cfg.logOutput.format)
+		d.closeLogFileLocked()
+		d.logOutput = cfg.logOutput
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.logOutput.file), 0755); err != nil {
+		d.log.Op("config").Errorf("couldn't open the log file, logging where it went before: %v", err)
+		return
+	}
+
+	f, err := logging.OpenRotatingFile(cfg.logOutput.file, cfg.logOutput.maxSize, cfg.logOutput.maxBackups)
+	if err != nil {
+		d.log.Op("config").Errorf("couldn't open the log file, logging where it went before: %v", err)
+		return
+	}
+
+	d.log.SetOutput(f, cfg.logOutput.format)
+	d.closeLogFileLocked()
+	d.logFile = f
+	d.logOutput = cfg.logOutput
+}
+
+func (d *Daemon) closeLogFile() {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	d.closeLogFileLocked()
+}
+
+func (d *Daemon) closeLogFileLocked() {
+	if d.logFile != nil {
+		d.logFile.Close()
+		d.logFile = nil
+	}
+}
@@ -0,0 +1,270 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkDurationBuckets are the upper bounds, in seconds, of the buckets of
+// the check duration histogram
+var checkDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// skipReasons turn the reasons given by ShouldSave into label values. The
+// reasons include numbers, so they can't be used as labels as they are.
+var skipReasons = []struct {
+	prefix string
+	label  string
+}{
+	{"user has commited during allowed time", "user_committed_recently"},
+	{"autosaved has commmited during allowed time", "autosaved_recently"},
+	{"not enough changes", "not_enough_changes"},
+	{"user commit is up to date", "no_changes"},
+	{"autosaved commit is up to date", "no_changes"},
+	{"nothing to save", "nothing_to_save"},
+	{"HEAD is on autosaved's own branch", "on_autosaved_branch"},
+}
+
+func skipReasonLabel(reason string) string {
+	for _, r := range skipReasons {
+		if strings.HasPrefix(reason, r.prefix) {
+			return r.label
+		}
+	}
+
+	return "other"
+}
+
+type histogram struct {
+	// counts holds the number of observations in each bucket, and in the
+	// last one, the ones that are bigger than every bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(checkDurationBuckets)+1)
+	}
+
+	i := sort.SearchFloat64s(checkDurationBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type skipKey struct {
+	repo   string
+	reason string
+}
+
+// metrics counts what CheckRepo and CheckAllRepos do, to be served in the
+// Prometheus text format
+type metrics struct {
+	mu        sync.Mutex
+	saves     map[string]uint64
+	skips     map[skipKey]uint64
+	errors    map[string]uint64
+	durations map[string]*histogram
+	watched   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		saves:     make(map[string]uint64),
+		skips:     make(map[skipKey]uint64),
+		errors:    make(map[string]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+func (m *metrics) save(repo string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saves[repo]++
+}
+
+func (m *metrics) skip(repo, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.skips[skipKey{repo, skipReasonLabel(reason)}]++
+}
+
+// check records a check that took d, and failed if failed is set
+func (m *metrics) check(repo string, d time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.durations[repo]
+	if !ok {
+		h = &histogram{}
+		m.durations[repo] = h
+	}
+	h.observe(d.Seconds())
+
+	if failed {
+		m.errors[repo]++
+	}
+}
+
+func (m *metrics) setWatched(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.watched = n
+}
+
+// write writes the metrics in the Prometheus text format. lastSaves has the
+// time of the latest save of each repository.
+func (m *metrics) write(b *bytes.Buffer, lastSaves map[string]time.Time, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(b, "autosaved_saves_total", "counter", "Checkpoints saved by the daemon.")
+	for _, repo := range sortedKeys(m.saves) {
+		fmt.Fprintf(b, "autosaved_saves_total{repo=%s} %d\n", labelValue(repo), m.saves[repo])
+	}
+
+	writeHeader(b, "autosaved_skips_total", "counter", "Checks that didn't save, by reason.")
+	skips := make([]skipKey, 0, len(m.skips))
+	for k := range m.skips {
+		skips = append(skips, k)
+	}
+	sort.Slice(skips, func(i, j int) bool {
+		if skips[i].repo != skips[j].repo {
+			return skips[i].repo < skips[j].repo
+		}
+		return skips[i].reason < skips[j].reason
+	})
+	for _, k := range skips {
+		fmt.Fprintf(b, "autosaved_skips_total{repo=%s,reason=%s} %d\n", labelValue(k.repo), labelValue(k.reason), m.skips[k])
+	}
+
+	writeHeader(b, "autosaved_check_errors_total", "counter", "Checks that failed.")
+	for _, repo := range sortedKeys(m.errors) {
+		fmt.Fprintf(b, "autosaved_check_errors_total{repo=%s} %d\n", labelValue(repo), m.errors[repo])
+	}
+
+	writeHeader(b, "autosaved_check_duration_seconds", "histogram", "How long checks took.")
+	repos := make([]string, 0, len(m.durations))
+	for repo := range m.durations {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		h := m.durations[repo]
+		label := labelValue(repo)
+
+		var cumulative uint64
+		for i, le := range checkDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "autosaved_check_duration_seconds_bucket{repo=%s,le=\"%g\"} %d\n", label, le, cumulative)
+		}
+		fmt.Fprintf(b, "autosaved_check_duration_seconds_bucket{repo=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(b, "autosaved_check_duration_seconds_sum{repo=%s} %g\n", label, h.sum)
+		fmt.Fprintf(b, "autosaved_check_duration_seconds_count{repo=%s} %d\n", label, h.count)
+	}
+
+	writeHeader(b, "autosaved_seconds_since_last_save", "gauge", "Time since the latest checkpoint saved by the daemon.")
+	saved := make([]string, 0, len(lastSaves))
+	for repo := range lastSaves {
+		saved = append(saved, repo)
+	}
+	sort.Strings(saved)
+	for _, repo := range saved {
+		fmt.Fprintf(b, "autosaved_seconds_since_last_save{repo=%s} %g\n", labelValue(repo), now.Sub(lastSaves[repo]).Seconds())
+	}
+
+	writeHeader(b, "autosaved_watched_repositories", "gauge", "Repositories being watched.")
+	fmt.Fprintf(b, "autosaved_watched_repositories %d\n", m.watched)
+}
+
+func writeHeader(b *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// serveMetrics answers with the metrics of the daemon
+func (d *Daemon) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	cfg := d.currentConfig()
+
+	lastSaves := make(map[string]time.Time)
+	d.mu.Lock()
+	for repo := range cfg.repositories {
+		if a, ok := d.activity[repo]; ok && !a.LastSave.IsZero() {
+			lastSaves[repo] = a.LastSave
+		}
+	}
+	d.mu.Unlock()
+
+	var b bytes.Buffer
+	d.metrics.write(&b, lastSaves, time.Now())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// syncMetricsServer starts, stops or moves the metrics listener to match the
+// config. It is only called from the main loop.
+func (d *Daemon) syncMetricsServer() {
+	addr := d.currentConfig().metricsAddress
+	if addr == d.metricsAddress && (addr == "") == (d.metricsServer == nil) {
+		return
+	}
+
+	d.stopMetricsServer()
+	d.metricsAddress = addr
+	if addr == "" {
+		return
+	}
+
+	log := d.log.Op("metrics")
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("couldn't serve metrics on %s: %v", addr, err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	d.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func(srv *http.Server) {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("stopped serving metrics: %v", err)
+		}
+	}(d.metricsServer)
+
+	log.Infof("serving metrics on http://%s/metrics", l.Addr())
+}
+
+func (d *Daemon) stopMetricsServer() {
+	if d.metricsServer != nil {
+		d.metricsServer.Close()
+		d.metricsServer = nil
+	}
+}
@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikochiko/autosaved/core"
+)
+
+// enqueue checks the repository on the worker pool. A repository is checked
+// by one worker at a time: if a check is already queued or running, it is
+// run once more after that one finishes instead, so no change is missed.
+func (d *Daemon) enqueue(path string, repo *core.AsdRepository) {
+	d.mu.Lock()
+	// checked under the lock, so that teardown can wait for every check
+	// that got started
+	if d.ctx.Err() != nil || d.isPaused() {
+		d.mu.Unlock()
+		return
+	}
+
+	if _, ok := d.busy[path]; ok {
+		d.busy[path] = true
+		d.mu.Unlock()
+		return
+	}
+
+	d.busy[path] = false
+	slots := d.workerSlots
+	d.running.Add(1)
+	d.mu.Unlock()
+
+	go func() {
+		defer d.running.Done()
+
+		for {
+			select {
+			case slots <- struct{}{}:
+			case <-d.ctx.Done():
+				d.mu.Lock()
+				delete(d.busy, path)
+				d.mu.Unlock()
+				return
+			}
+
+			d.checkRepoSafely(path, repo)
+			<-slots
+
+			d.mu.Lock()
+			again := d.busy[path]
+			if !again || d.ctx.Err() != nil {
+				delete(d.busy, path)
+				d.mu.Unlock()
+				return
+			}
+
+			d.busy[path] = false
+			d.mu.Unlock()
+		}
+	}()
+}
+
+// lockRepo keeps others from working on the repository until the returned
+// function is called
+func (d *Daemon) lockRepo(path string) func() {
+	d.mu.Lock()
+	l, ok := d.repoLocks[path]
+	if !ok {
+		l = &sync.Mutex{}
+		d.repoLocks[path] = l
+	}
+	d.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// enqueueStaggered spreads the checks of the repositories evenly over the
+// checking interval, so that they don't all read their files at once. Each
+// repository is looked up in the config again when its turn comes.
+func (d *Daemon) enqueueStaggered(repos map[string]*core.AsdRepository) {
+	if len(repos) == 0 {
+		return
+	}
+
+	paths := make([]string, 0, len(repos))
+	for path := range repos {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	step := d.currentConfig().pollingInterval() / time.Duration(len(paths))
+	for i, path := range paths {
+		path := path
+
+		if i == 0 {
+			d.enqueue(path, repos[path])
+			continue
+		}
+
+		// t is set under d.mu, which the timer takes before reading it
+		d.mu.Lock()
+		if pending, ok := d.staggered[path]; ok {
+			pending.Stop()
+		}
+
+		var t *time.Timer
+		t = time.AfterFunc(time.Duration(i)*step, func() {
+			d.mu.Lock()
+			if d.staggered[path] == t {
+				delete(d.staggered, path)
+			}
+			d.mu.Unlock()
+
+			// the repository may have been unwatched or reloaded since
+			repo, ok := d.currentConfig().repositories[path]
+			if !ok {
+				return
+			}
+
+			d.enqueue(path, repo)
+		})
+		d.staggered[path] = t
+		d.mu.Unlock()
+	}
+}
+
+// stopStaggered stops the checks queued by enqueueStaggered that haven't
+// started yet
+func (d *Daemon) stopStaggered() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for path, t := range d.staggered {
+		t.Stop()
+		delete(d.staggered, path)
+	}
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"github.com/nikochiko/autosaved/core"
+)
+
+// recoverRepo cleans up after saves that were interrupted in the repository,
+// and reports whether it can be checked. It is false while the repository
+// is left in a state that needs the user to fix it. d.lockRepo(path) must be
+// held.
+func (d *Daemon) recoverRepo(path string, repo *core.AsdRepository) bool {
+	log := d.log.Repo(path).Op("recover")
+
+	rec, err := repo.Recover()
+	for _, action := range rec.Actions {
+		log.Infof("%s", action)
+	}
+	if err != nil {
+		// let the check go ahead, it will fail and be retried if the
+		// repository really is broken
+		log.Warnf("couldn't look for interrupted saves: %v", err)
+		return true
+	}
+
+	if d.setAttention(path, rec.Problem) {
+		if rec.Problem != "" {
+			log.Warnf("not autosaving: %s", rec.Problem)
+		} else {
+			log.Infof("autosaving again")
+		}
+
+		d.writeState()
+	}
+
+	return rec.Problem == ""
+}
+
+// recoverAll runs recoverRepo on every repository, so that one left behind
+// by a crash is fixed right away instead of on its first check
+func (d *Daemon) recoverAll() {
+	for path, repo := range d.currentConfig().repositories {
+		unlock := d.lockRepo(path)
+		d.recoverRepo(path, repo)
+		unlock()
+	}
+}
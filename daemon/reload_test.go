@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	viperPkg "github.com/spf13/viper"
+)
+
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "a"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err = w.Commit("first", &git.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func writeTestConfig(t *testing.T, file, repo string, workers int) {
+	t.Helper()
+
+	config := fmt.Sprintf("repositories:\n  - %s\nafter_every:\n  seconds: 0\nworkers: %d\n", repo, workers)
+	if err := os.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReloadWhileChecking reloads the config, sometimes with invalid
+// values, while the repository is checked and the status is read. Run with
+// -race.
+func TestReloadWhileChecking(t *testing.T) {
+	repo := newTestRepo(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "autosaved.yaml")
+	writeTestConfig(t, file, repo, 2)
+
+	v := viperPkg.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(v, filepath.Join(dir, "autosaved.lock"), io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.cancel()
+
+	const rounds = 20
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < rounds; i++ {
+			if i%2 == 0 {
+				// workers must be positive
+				writeTestConfig(t, file, repo, 0)
+				if err := d.reload(); err == nil {
+					t.Error("an invalid config was loaded")
+				}
+
+				d.viperMu.Lock()
+				workers := d.viper.GetInt(workersKey)
+				d.viperMu.Unlock()
+				if workers < 1 {
+					t.Errorf("an invalid config changed viper, workers is %d", workers)
+				}
+			} else {
+				writeTestConfig(t, file, repo, i)
+				if err := d.reload(); err != nil {
+					t.Errorf("reloading: %v", err)
+				}
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < rounds; i++ {
+			name := filepath.Join(repo, "a")
+			if err := os.WriteFile(name, []byte(fmt.Sprintf("a %d\n", i)), 0644); err != nil {
+				t.Error(err)
+				return
+			}
+
+			for path, asdRepo := range d.currentConfig().repositories {
+				d.checkRepoSafely(path, asdRepo)
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < rounds; i++ {
+			if s := d.status(); len(s.Repositories) != 1 {
+				t.Errorf("status shows %d repositories", len(s.Repositories))
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if cfg := d.currentConfig(); cfg.workers != rounds-1 {
+		t.Errorf("expected the last valid config with %d workers, got %d", rounds-1, cfg.workers)
+	}
+}
@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFile sits next to the lockfile
+const stateFile = ".autosaved.state.json"
+
+// stateVersion is bumped when the state file changes incompatibly. A state
+// file of another version is ignored.
+const stateVersion = 1
+
+// daemonState is what the daemon knows about the repositories, kept on disk
+// so that it survives restarts
+type daemonState struct {
+	Version  int                      `json:"version"`
+	Activity map[string]*repoActivity `json:"activity"`
+	Failures map[string]*failureState `json:"failures,omitempty"`
+}
+
+// failureState is a repoFailure as it's written to the state file
+type failureState struct {
+	Error       string    `json:"error"`
+	Count       int       `json:"count"`
+	Since       time.Time `json:"since"`
+	RetryAt     time.Time `json:"retry_at"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// StatePath returns the path of the state file of the daemon using the
+// lockfile
+func StatePath(lockfilePath string) string {
+	return filepath.Join(filepath.Dir(lockfilePath), stateFile)
+}
+
+// loadState reads the state file into the daemon. A missing or unreadable
+// state file only means starting from scratch.
+func (d *Daemon) loadState() {
+	path := StatePath(d.lockfilePath)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.log.Op("state").Warnf("couldn't read the state file %s, starting afresh: %v", path, err)
+		}
+		return
+	}
+
+	var s daemonState
+	if err = json.Unmarshal(data, &s); err != nil {
+		d.log.Op("state").Warnf("couldn't read the state file %s, starting afresh: %v", path, err)
+		return
+	}
+
+	if s.Version != stateVersion {
+		d.log.Op("state").Warnf("the state file %s is of version %d instead of %d, starting afresh", path, s.Version, stateVersion)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for repo, a := range s.Activity {
+		if a != nil {
+			d.activity[repo] = a
+		}
+	}
+
+	for repo, f := range s.Failures {
+		if f == nil {
+			continue
+		}
+
+		d.failures[repo] = &repoFailure{
+			err:         errors.New(f.Error),
+			count:       f.Count,
+			since:       f.Since,
+			retryAt:     f.RetryAt,
+			fingerprint: f.Fingerprint,
+		}
+	}
+}
+
+// writeState writes the state file through a rename, so that it is never
+// left half written. Failing to write it isn't fatal.
+func (d *Daemon) writeState() {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	d.mu.Lock()
+	s := daemonState{Version: stateVersion, Activity: d.activity, Failures: make(map[string]*failureState)}
+	for repo, f := range d.failures {
+		s.Failures[repo] = &failureState{
+			Error:       f.err.Error(),
+			Count:       f.count,
+			Since:       f.since,
+			RetryAt:     f.retryAt,
+			Fingerprint: f.fingerprint,
+		}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	d.mu.Unlock()
+	if err != nil {
+		d.log.Op("state").Warnf("couldn't write the state file: %v", err)
+		return
+	}
+
+	if err = writeFileAtomic(StatePath(d.lockfilePath), data); err != nil {
+		d.log.Op("state").Warnf("couldn't write the state file: %v", err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	// make sure the contents are on disk before the rename is
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,281 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/nikochiko/autosaved/logging"
+)
+
+var ErrWatchLimit = errors.New("reached the limit of inotify watches or instances")
+
+// repoWatcher watches the files of a repository, except for the ignored ones,
+// and sends the repository's path on ready once they have been quiet for the
+// debounce window
+type repoWatcher struct {
+	path    string
+	root    string
+	watcher *fsnotify.Watcher
+	ready   chan<- string
+	log     *logging.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// failed is set when the watcher had to give up, the repository is
+	// polled then
+	failed int32
+
+	mu       sync.Mutex
+	ignore   gitignore.Matcher
+	debounce time.Duration
+	timer    *time.Timer
+	// due is when timer fires
+	due time.Time
+}
+
+func newRepoWatcher(ctx context.Context, path string, debounce time.Duration, ready chan<- string, log *logging.Logger) (*repoWatcher, error) {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		if isWatchLimit(err) {
+			return nil, fmt.Errorf("%w: %v", ErrWatchLimit, err)
+		}
+
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	rw := &repoWatcher{
+		path:     path,
+		root:     root,
+		watcher:  watcher,
+		ready:    ready,
+		log:      log.Repo(path).Op("watch"),
+		ctx:      ctx,
+		cancel:   cancel,
+		debounce: debounce,
+	}
+
+	rw.loadIgnorePatterns()
+
+	if err = rw.addRecursive(root); err != nil {
+		rw.close()
+		return nil, err
+	}
+
+	go rw.run()
+
+	return rw, nil
+}
+
+// loadIgnorePatterns reads the .gitignore files of the repository and its
+// .git/info/exclude
+func (rw *repoWatcher) loadIgnorePatterns() {
+	fs := osfs.New(rw.root)
+
+	patterns, _ := gitignore.ReadPatterns(fs, nil)
+
+	if f, err := fs.Open(filepath.Join(".git", "info", "exclude")); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			patterns = append(patterns, gitignore.ParsePattern(line, nil))
+		}
+		f.Close()
+	}
+
+	rw.mu.Lock()
+	rw.ignore = gitignore.NewMatcher(patterns)
+	rw.mu.Unlock()
+}
+
+// ignored reports whether changes to the path don't matter for saving
+func (rw *repoWatcher) ignored(path string, isDir bool) bool {
+	rel, err := filepath.Rel(rw.root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if parts[0] == ".git" {
+		return true
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.ignore.Match(parts, isDir)
+}
+
+// addRecursive watches dir and all of the directories under it that aren't
+// ignored. It only fails when the watch limit is reached.
+func (rw *repoWatcher) addRecursive(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the directory may be gone already, or be unreadable
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if rw.ignored(path, true) {
+			return filepath.SkipDir
+		}
+
+		if err = rw.watcher.Add(path); err != nil && isWatchLimit(err) {
+			return fmt.Errorf("%w: %v", ErrWatchLimit, err)
+		}
+
+		return nil
+	})
+}
+
+func (rw *repoWatcher) run() {
+	for {
+		select {
+		case event, ok := <-rw.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if err := rw.handle(event); err != nil {
+				rw.log.Warnf("stopped watching files, polling the repository instead: %v", err)
+				atomic.StoreInt32(&rw.failed, 1)
+				rw.close()
+				return
+			}
+		case err, ok := <-rw.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			// events may have been dropped, so check anyway
+			rw.log.Warnf("error while watching files: %v", err)
+			rw.schedule(rw.debounceWindow())
+		case <-rw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (rw *repoWatcher) handle(event fsnotify.Event) error {
+	isDir := false
+	if info, err := os.Lstat(event.Name); err == nil {
+		isDir = info.IsDir()
+	}
+
+	if filepath.Base(event.Name) == ".gitignore" {
+		rw.loadIgnorePatterns()
+	}
+
+	if rw.ignored(event.Name, isDir) {
+		return nil
+	}
+
+	if isDir && event.Op&fsnotify.Create != 0 {
+		if err := rw.addRecursive(event.Name); err != nil {
+			return err
+		}
+	}
+
+	rw.schedule(rw.debounceWindow())
+	return nil
+}
+
+// schedule sends the repository on ready after d, unless something else gets
+// scheduled before that
+func (rw *repoWatcher) schedule(d time.Duration) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.timer != nil {
+		rw.timer.Stop()
+	}
+
+	rw.due = time.Now().Add(d)
+	rw.timer = time.AfterFunc(d, func() {
+		select {
+		case rw.ready <- rw.path:
+		case <-rw.ctx.Done():
+		}
+	})
+}
+
+// recheckAt makes sure the repository is checked again at t, when edits that
+// came too early to be saved can be saved
+func (rw *repoWatcher) recheckAt(t time.Time) {
+	rw.schedule(time.Until(t))
+}
+
+// nextCheck returns when the repository will be checked next, or the zero
+// time if that waits for its files to change
+func (rw *repoWatcher) nextCheck() time.Time {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.due.Before(time.Now()) {
+		return time.Time{}
+	}
+
+	return rw.due
+}
+
+func (rw *repoWatcher) debounceWindow() time.Duration {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.debounce
+}
+
+func (rw *repoWatcher) setDebounceWindow(d time.Duration) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.debounce = d
+}
+
+// hasFailed reports whether the repository has to be polled
+func (rw *repoWatcher) hasFailed() bool {
+	return atomic.LoadInt32(&rw.failed) == 1
+}
+
+func (rw *repoWatcher) close() {
+	rw.cancel()
+	rw.watcher.Close()
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.timer != nil {
+		rw.timer.Stop()
+	}
+}
+
+// isWatchLimit reports whether err comes from running out of inotify watches
+// (ENOSPC) or instances (EMFILE)
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
@@ -0,0 +1,275 @@
+// Package logging writes leveled log lines in logfmt or JSON. Loggers carry
+// fields, like the repository and the operation a line is about, which are
+// written on every line.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+
+	return levelNames[l]
+}
+
+type Format int
+
+const (
+	FormatLogfmt Format = iota
+	FormatJSON
+)
+
+var (
+	ErrUnknownLevel  = errors.New("unknown log level, use one of debug, info, warn or error")
+	ErrUnknownFormat = errors.New("unknown log format, use logfmt or json")
+)
+
+// ParseLevel reads a level written like in the config file
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(s)
+	if s == "warning" {
+		s = "warn"
+	}
+
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("%w: %q", ErrUnknownLevel, s)
+}
+
+// ParseFormat reads a format written like in the config file
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "logfmt":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+
+	return FormatLogfmt, fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+// sink is shared by a logger and the loggers made from it with With
+type sink struct {
+	level int32
+
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes log lines to a writer. It is safe to use from several
+// goroutines.
+type Logger struct {
+	sink   *sink
+	fields []field
+}
+
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{sink: &sink{level: int32(level), w: w, format: format}}
+}
+
+// With returns a logger adding the key and value pairs to every line. A key
+// that the logger already has is replaced.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := append([]field(nil), l.fields...)
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		f := field{key: fmt.Sprint(kv[i]), value: kv[i+1]}
+
+		replaced := false
+		for j := range fields {
+			if fields[j].key == f.key {
+				fields[j] = f
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			fields = append(fields, f)
+		}
+	}
+
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Repo returns a logger for lines about the repository at path
+func (l *Logger) Repo(path string) *Logger {
+	return l.With("repo", path)
+}
+
+// Op returns a logger for lines about the operation op, like "save"
+func (l *Logger) Op(op string) *Logger {
+	return l.With("op", op)
+}
+
+// SetLevel changes the level of the logger, and of all loggers sharing its
+// output
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.sink.level))
+}
+
+// SetOutput changes where the logger, and all loggers sharing its output,
+// write to. It returns the writer used until now, for the caller to close.
+func (l *Logger) SetOutput(w io.Writer, format Format) io.Writer {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	old := l.sink.w
+	l.sink.w = w
+	l.sink.format = format
+	return old
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args)
+}
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]field, 0, len(l.fields)+3)
+	fields = append(fields, field{"time", time.Now()}, field{"level", level})
+	fields = append(fields, l.fields...)
+	fields = append(fields, field{"msg", fmt.Sprintf(format, args...)})
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	var line []byte
+	if l.sink.format == FormatJSON {
+		line = encodeJSON(fields)
+	} else {
+		line = encodeLogfmt(fields)
+	}
+
+	// there is nowhere to report a failed write to
+	l.sink.w.Write(line)
+}
+
+// plainValue turns values that don't print well as they are into strings
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return v
+}
+
+func encodeLogfmt(fields []field) []byte {
+	var b bytes.Buffer
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		b.WriteString(f.key)
+		b.WriteByte('=')
+
+		s := fmt.Sprint(plainValue(f.value))
+		if needsQuoting(s) {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodeJSON writes the fields as a JSON object, keeping their order
+func encodeJSON(fields []field) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+
+		value, err := json.Marshal(plainValue(f.value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+
+	return b.Bytes()
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is moved aside once it grows past a size.
+// The file at path is always the newest, path.1 the one before it, and so on
+// up to the number of backups kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at path for appending. A maxSize of 0
+// never rotates it.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) Path() string {
+	return rf.path
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a new
+// file
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+
+	if rf.maxBackups < 1 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return rf.open()
+	}
+
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(rf.path, rf.backupPath(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *RotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,140 @@
+// Package output renders what commands print, either for people or in a
+// format that scripts and editor plugins can read.
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/xeonx/timeago"
+)
+
+var ErrMoreThanOneFormat = errors.New("only one of --json, --jsonl and --format can be used")
+
+// Renderer writes a value, usually the result of a command, to w
+type Renderer interface {
+	Render(w io.Writer, v interface{}) error
+}
+
+// RendererFunc lets a function be used as a Renderer, like the ones printing
+// for people
+type RendererFunc func(w io.Writer, v interface{}) error
+
+func (f RendererFunc) Render(w io.Writer, v interface{}) error {
+	return f(w, v)
+}
+
+// JSON writes the value as one indented JSON document
+type JSON struct{}
+
+func (JSON) Render(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// JSONLines writes each item of a slice as a JSON object on its own line.
+// Anything else is written as a single line.
+type JSONLines struct{}
+
+func (JSONLines) Render(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	return eachItem(v, func(item interface{}) error {
+		return enc.Encode(item)
+	})
+}
+
+// Template executes a Go template for each item of a slice, or once for
+// anything else, ending each with a newline
+type Template struct {
+	t *template.Template
+}
+
+// templateFuncs are available in --format templates on top of the builtin
+// ones
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"short": func(hash string) string {
+		if len(hash) > 7 {
+			return hash[:7]
+		}
+		return hash
+	},
+	"ago":  timeago.English.Format,
+	"trim": strings.TrimSpace,
+}
+
+func NewTemplate(text string) (*Template, error) {
+	t, err := template.New("format").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{t: t}, nil
+}
+
+func (t *Template) Render(w io.Writer, v interface{}) error {
+	return eachItem(v, func(item interface{}) error {
+		var b strings.Builder
+		if err := t.t.Execute(&b, item); err != nil {
+			return err
+		}
+
+		s := b.String()
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+
+		_, err := io.WriteString(w, s)
+		return err
+	})
+}
+
+// Select picks the renderer asked for with the --json, --jsonl or --format
+// flags, or text when none of them is set
+func Select(asJSON, asJSONLines bool, format string, text Renderer) (Renderer, error) {
+	chosen := 0
+	for _, set := range []bool{asJSON, asJSONLines, format != ""} {
+		if set {
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		return nil, ErrMoreThanOneFormat
+	}
+
+	switch {
+	case asJSON:
+		return JSON{}, nil
+	case asJSONLines:
+		return JSONLines{}, nil
+	case format != "":
+		return NewTemplate(format)
+	}
+
+	return text, nil
+}
+
+// eachItem calls f with each item of v if it is a slice, and with v itself
+// otherwise
+func eachItem(v interface{}, f func(interface{}) error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return f(v)
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := f(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}